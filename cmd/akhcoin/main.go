@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/alholm/akhcoin/internal/p2p"
@@ -14,8 +15,11 @@ import (
 	"github.com/alholm/akhcoin/internal/node"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-crypto"
+	"github.com/libp2p/go-libp2p-peer"
 	"strconv"
+	"strings"
 	"github.com/spf13/viper"
+	"time"
 )
 
 var log = logging.Logger("main")
@@ -24,9 +28,10 @@ const privateKeyFileName = "id_rsa"
 
 func main() {
 	//1st launch, we didn't discover any nodes yet, so we have 3 options: (for more details see https://en.bitcoin.it/wiki/Bitcoin_Core_0.11_(ch_4):_P2P_Network)
-	//1) hardcoded nodes
-	//2) DNS seeding: on this stage no domains registered, skipping
-	//3) User-specified on the command line
+	//1) hardcoded nodes: p2p.bootstrap in config
+	//2) DNS seeding: p2p.dnsSeeds in config, on this stage no domains registered
+	//3) User-specified on the command line: -b flags
+	//Peers discovered are kept in address book and used first on next start
 
 	logging.LevelError()
 	//logging.LevelDebug() //to see all libp2p debug messages :
@@ -46,9 +51,23 @@ func main() {
 		fmt.Sprintf("port where to start local host, %d will be used by default", p2p.DefaultPort))
 	keyPath := flag.String("k", "",
 		fmt.Sprintf("path to private key file, will be attempted to read from \"%s\" in current directory by default", privateKeyFileName))
+	var bootstrap stringsFlag
+	flag.Var(&bootstrap, "b", "bootstrap peer address in format /ip4/<IP>/tcp/<port>/ipfs/<peer ID>, may be repeated")
+	swarmKeyPath := flag.String("genpsk", "", "generate private network pre-shared key to the file and exit")
 
 	flag.Parse()
 
+	if len(*swarmKeyPath) > 0 {
+		err = generateSwarmKey(*swarmKeyPath)
+		if err != nil {
+			console.Fatalf("Failed to generate private network key: %s", err)
+		}
+		console.Printf("Private network key saved to %s, set p2p.swarmKey to its path on every node of the network", *swarmKeyPath)
+		return
+	}
+
+	viper.Set("p2p.bootstrap", append(viper.GetStringSlice("p2p.bootstrap"), bootstrap...))
+
 	console.Println("AkhCoin 0.1. Welcome!")
 
 	var keyBytes []byte
@@ -158,6 +177,62 @@ func main() {
 		},
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "withdraw",
+		Help: "withdraw vote, format: withdraw <Peer ID>",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) == 0 {
+				c.Err(fmt.Errorf("not enough arguments"))
+				return
+			}
+			err := akhNode.Withdraw(c.Args[0])
+			if err != nil {
+				c.Err(err)
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "finality",
+		Help: "show the last irreversible block or finality of the block, format: finality [block hash]",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) > 0 {
+				c.Printf("%s %s\n", c.Args[0], akhNode.Finality(c.Args[0]))
+				return
+			}
+			irreversible := akhNode.LastIrreversible()
+			c.Printf("last irreversible block: %s\n", irreversible.Hash)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "checkpoint",
+		Help: "print the head as checkpoint line to hardcode on release",
+		Func: func(c *ishell.Context) {
+			c.Println(akhNode.Checkpoint())
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "payments",
+		Help: "list payments sent and received with their finality",
+		Func: func(c *ishell.Context) {
+			for _, p := range akhNode.Payments() {
+				c.Printf("%s in block %s: %s\n", &p.Transaction, p.BlockHash, p.Finality)
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "delegates",
+		Help: "show produced, missed and late blocks of delegates since they were skipped last time",
+		Func: func(c *ishell.Context) {
+			for id, stats := range akhNode.Reliability() {
+				c.Printf("%s produced: %d missed: %d late: %d\n", id, stats.Produced, stats.Missed, stats.Late)
+			}
+		},
+	})
+
 	shell.AddCmd(&ishell.Cmd{
 		Name: "-ap",
 		Help: "add peer, format: -ap <IP>[:port] <peer ID>",
@@ -173,6 +248,117 @@ func main() {
 		},
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "peers",
+		Help: "list known peers with their scores and bans",
+		Func: func(c *ishell.Context) {
+			scores := akhNode.Host.Scores.Scores()
+			for _, peerId := range akhNode.Host.Peerstore().Peers() {
+				if peerId == akhNode.Host.ID() {
+					continue
+				}
+				c.Printf("%s score: %d\n", peerId.Pretty(), scores[peerId])
+			}
+			for peerId, until := range akhNode.Host.Scores.Bans() {
+				c.Printf("%s banned until %s\n", peerId.Pretty(), until.Format(time.RFC3339))
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "handlers",
+		Help: "show incoming streams statistics by protocol",
+		Func: func(c *ishell.Context) {
+			for proto, stats := range akhNode.Host.HandlerStats() {
+				c.Printf("%s %s\n", proto, stats)
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "ban",
+		Help: "ban peer, format: ban <Peer ID> [minutes]",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) == 0 {
+				c.Err(fmt.Errorf("not enough arguments"))
+				return
+			}
+			peerId, err := peer.IDB58Decode(c.Args[0])
+			if err != nil {
+				c.Err(err)
+				return
+			}
+			var period time.Duration
+			if len(c.Args) > 1 {
+				minutes, err := strconv.ParseUint(c.Args[1], 0, 64)
+				if err != nil {
+					c.Err(err)
+					return
+				}
+				period = time.Duration(minutes) * time.Minute
+			}
+			akhNode.Host.BanPeer(peerId, period)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "unban",
+		Help: "unban peer, format: unban <Peer ID>",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) == 0 {
+				c.Err(fmt.Errorf("not enough arguments"))
+				return
+			}
+			peerId, err := peer.IDB58Decode(c.Args[0])
+			if err != nil {
+				c.Err(err)
+				return
+			}
+			akhNode.Host.UnbanPeer(peerId)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "reindex",
+		Help: "rebuild consensus and balances state by replaying the chain from genesis",
+		Func: func(c *ishell.Context) {
+			err := akhNode.Reindex()
+			if err != nil {
+				c.Err(err)
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "exportprotection",
+		Help: "export slashing protection records before moving delegate to another machine, format: exportprotection <path>",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) == 0 {
+				c.Err(fmt.Errorf("not enough arguments"))
+				return
+			}
+			err := akhNode.ExportProtection(c.Args[0])
+			if err != nil {
+				c.Err(err)
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "importprotection",
+		Help: "import slashing protection records exported on another machine, format: importprotection <path>",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) == 0 {
+				c.Err(fmt.Errorf("not enough arguments"))
+				return
+			}
+			err := akhNode.ImportProtection(c.Args[0])
+			if err != nil {
+				c.Err(err)
+			}
+		},
+	})
+
 	shell.Print(shell.HelpText())
 
 	shell.Run()
@@ -180,6 +366,18 @@ func main() {
 	akhNode.Host.Close()
 }
 
+//stringsFlag collects values of repeated command line flag
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func generateAndDumpKeys() (privateBytes []byte, err error) {
 	private, public, _ := blockchain.NewKeys()
 	privateBytes, _ = crypto.MarshalPrivateKey(private)
@@ -192,10 +390,31 @@ func generateAndDumpKeys() (privateBytes []byte, err error) {
 	return
 }
 
+func generateSwarmKey(path string) error {
+	contents, err := p2p.GenerateSwarmKey()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(contents), 0600)
+}
+
 func startHttpServer(akhNode *node.AkhNode, port *int) {
 	viewHandler := func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "<h1>%s</h1>", akhNode.Head.Hash)
 	}
 	http.HandleFunc("/", viewHandler)
+	finalityHandler := func(w http.ResponseWriter, r *http.Request) {
+		if hash := r.URL.Query().Get("block"); hash != "" {
+			fmt.Fprintf(w, "%s", akhNode.Finality(hash))
+			return
+		}
+		fmt.Fprintf(w, "%s", akhNode.LastIrreversible().Hash)
+	}
+	http.HandleFunc("/finality", finalityHandler)
+	delegatesHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(akhNode.Reliability())
+	}
+	http.HandleFunc("/delegates", delegatesHandler)
 	go http.ListenAndServe(fmt.Sprintf(":%d", *port-1000), nil)
 }
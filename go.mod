@@ -30,7 +30,7 @@ require (
 	github.com/libp2p/go-libp2p-host v3.0.4+incompatible // indirect
 	github.com/libp2p/go-libp2p-interface-conn v0.0.0-20180119205146-4fb1080424cc // indirect
 	github.com/libp2p/go-libp2p-interface-connmgr v0.0.11 // indirect
-	github.com/libp2p/go-libp2p-interface-pnet v0.0.0-20180119205146-10f6da115a71 // indirect
+	github.com/libp2p/go-libp2p-interface-pnet v0.0.0-20180119205146-10f6da115a71
 	github.com/libp2p/go-libp2p-loggables v1.1.19 // indirect
 	github.com/libp2p/go-libp2p-metrics v2.0.3+incompatible // indirect
 	github.com/libp2p/go-libp2p-nat v0.8.4 // indirect
@@ -40,7 +40,7 @@ require (
 	github.com/libp2p/go-libp2p-protocol v1.0.0
 	github.com/libp2p/go-libp2p-secio v1.2.5 // indirect
 	github.com/libp2p/go-libp2p-swarm v2.1.3+incompatible
-	github.com/libp2p/go-libp2p-transport v2.2.12+incompatible // indirect
+	github.com/libp2p/go-libp2p-transport v2.2.12+incompatible
 	github.com/libp2p/go-maddr-filter v1.1.9 // indirect
 	github.com/libp2p/go-msgio v0.0.3 // indirect
 	github.com/libp2p/go-peerstream v2.1.5+incompatible // indirect
@@ -84,7 +84,7 @@ require (
 	github.com/whyrusleeping/mdns v0.0.0-20180724224618-ef8f1e9eacb7 // indirect
 	github.com/whyrusleeping/multiaddr-filter v0.0.0-20160516205228-e903e4adabd7 // indirect
 	github.com/whyrusleeping/yamux v0.0.0-20180713144751-cb29a700b01d // indirect
-	golang.org/x/crypto v0.0.0-20180807104621-f027049dab0a // indirect
+	golang.org/x/crypto v0.0.0-20180807104621-f027049dab0a
 	golang.org/x/net v0.0.0-20180801234040-f4c29de78a2a // indirect
 	golang.org/x/sys v0.0.0-20180806192500-2be389f392cd // indirect
 	gopkg.in/yaml.v2 v2.2.1 // indirect
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("checkpoints", map[string]string{})
+}
+
+//hardcodedCheckpoints are hashes of blocks at their heights by network, chain not passing them is rejected however long
+//it is. Updated on release with "checkpoint" command output of a synced node.
+var hardcodedCheckpoints = map[string]map[int64]string{
+	"main": {},
+}
+
+//checkpoints are hashes of blocks at their heights the chain has to pass
+type checkpoints map[int64]string
+
+//loadCheckpoints joins hardcoded checkpoints of the network with the configured ones, the latter take precedence
+func loadCheckpoints(network string) (c checkpoints, err error) {
+	c = make(checkpoints)
+	for height, hash := range hardcodedCheckpoints[network] {
+		c[height] = hash
+	}
+	for heightStr, hash := range viper.GetStringMapString("checkpoints") {
+		height, parseErr := strconv.ParseInt(heightStr, 10, 64)
+		if parseErr != nil || height < 0 {
+			return nil, fmt.Errorf("invalid checkpoint height %s", heightStr)
+		}
+		if hardcoded, ok := c[height]; ok && hardcoded != hash {
+			log.Warningf("Checkpoint %s at height %d overrides hardcoded %s\n", hash, height, hardcoded)
+		}
+		c[height] = hash
+	}
+	return
+}
+
+func (c checkpoints) verify(height int64, hash string) error {
+	if required, ok := c[height]; ok && required != hash {
+		return fmt.Errorf("block %s at height %d doesn't match checkpoint %s", hash, height, required)
+	}
+	return nil
+}
+
+//Checkpoint returns height and hash of the head in format of hardcoded checkpoints
+func (node *AkhNode) Checkpoint() string {
+	node.Lock()
+	defer node.Unlock()
+	return fmt.Sprintf("%d: \"%s\",", node.headHeight, node.Head.Hash)
+}
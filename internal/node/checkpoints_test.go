@@ -0,0 +1,33 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadCheckpoints(t *testing.T) {
+	hardcodedCheckpoints["test"] = map[int64]string{10: "hardcoded", 20: "overridden"}
+	defer delete(hardcodedCheckpoints, "test")
+	viper.Set("checkpoints", map[string]string{"20": "configured", "30": "added"})
+	defer viper.Set("checkpoints", map[string]string{})
+
+	c, err := loadCheckpoints("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 3 || c[10] != "hardcoded" || c[20] != "configured" || c[30] != "added" {
+		t.Fatalf("unexpected checkpoints: %v", c)
+	}
+	if c.verify(10, "hardcoded") != nil || c.verify(11, "any") != nil {
+		t.Error("block matching checkpoints rejected")
+	}
+	if c.verify(20, "overridden") == nil {
+		t.Error("block not matching checkpoint accepted")
+	}
+
+	viper.Set("checkpoints", map[string]string{"tip": "hash"})
+	if _, err = loadCheckpoints("test"); err == nil {
+		t.Error("invalid checkpoint height accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/alholm/akhcoin/internal/p2p"
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+//RejectionError explains why received transaction, vote or block was not accepted,
+//it is sent back to the peer the message came from
+type RejectionError struct {
+	Misbehavior p2p.Misbehavior
+	Err         error
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Misbehavior, e.Err)
+}
+
+//reject logs rejection, penalizes the peer and returns error to respond it with
+func (node *AkhNode) reject(peerId peer.ID, m p2p.Misbehavior, format string, args ...interface{}) error {
+	err := &RejectionError{m, fmt.Errorf(format, args...)}
+	log.Warningf("Rejected message from %s: %s\n", peerId.Pretty(), err)
+	node.Host.Penalize(peerId, m)
+	return err
+}
+
+//decline logs rejection of message out of its time window, peer isn't penalized as clocks skew routinely
+func (node *AkhNode) decline(peerId peer.ID, format string, args ...interface{}) error {
+	err := &RejectionError{p2p.Untimely, fmt.Errorf(format, args...)}
+	log.Infof("Declined message from %s: %s\n", peerId.Pretty(), err)
+	return err
+}
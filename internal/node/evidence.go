@@ -0,0 +1,133 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/alholm/akhcoin/internal/p2p"
+	. "github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+//evidencePool keeps equivocations known to the node, the ones not included in chain yet are pending.
+//Evidence of slots before the last irreversible block is forgotten, see prune.
+type evidencePool struct {
+	known   map[string]int64 //timestamps of equivocated blocks by evidence hash
+	pending []Equivocation
+	sync.Mutex
+}
+
+func newEvidencePool() *evidencePool {
+	return &evidencePool{known: make(map[string]int64)}
+}
+
+//add returns false if evidence is already known
+func (p *evidencePool) add(e Equivocation) bool {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.known[e.Hash()]; ok {
+		return false
+	}
+	p.known[e.Hash()] = e.First.GetTimestamp()
+	p.pending = append(p.pending, e)
+	return true
+}
+
+//prune forgets evidence of blocks produced before the timestamp
+func (p *evidencePool) prune(before int64) {
+	p.Lock()
+	defer p.Unlock()
+	for hash, timeStamp := range p.known {
+		if timeStamp < before {
+			delete(p.known, hash)
+		}
+	}
+	pending := p.pending[:0]
+	for _, e := range p.pending {
+		if e.First.GetTimestamp() >= before {
+			pending = append(pending, e)
+		}
+	}
+	p.pending = pending
+}
+
+func (p *evidencePool) getPending() []Equivocation {
+	p.Lock()
+	defer p.Unlock()
+	return append([]Equivocation(nil), p.pending...)
+}
+
+//included removes evidence included in block from pending
+func (p *evidencePool) included(evidence []Equivocation) {
+	p.Lock()
+	defer p.Unlock()
+	for _, e := range evidence {
+		p.known[e.Hash()] = e.First.GetTimestamp()
+		for i, pending := range p.pending {
+			if pending.Hash() == e.Hash() {
+				p.pending = append(p.pending[:i], p.pending[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+//observe checks whether block producer has signed another block for the same slot, block has to be timely and signed
+//by the producer scheduled for its slot
+func (node *AkhNode) observe(bd *BlockData) {
+	header := bd.Header()
+	if valid, _ := header.Verify(); !valid {
+		return
+	}
+	e := node.detector.Observe(header)
+	if e == nil {
+		return
+	}
+	log.Warningf("Equivocation detected: %s\n", e)
+	node.addEvidence(*e)
+}
+
+func (node *AkhNode) ReceiveEvidence(e Equivocation, peerId peer.ID) error {
+	node.Lock()
+	defer node.Unlock()
+
+	valid, err := e.Verify(node.engine.Period(), node.engine.Epsilon())
+	if !valid {
+		return node.reject(peerId, p2p.InvalidSignature, "invalid evidence %s: %s", &e, err)
+	}
+	if e.First.GetTimestamp() < node.irreversible.GetTimestamp() {
+		return node.decline(peerId, "evidence %s is older than the last irreversible block", &e)
+	}
+	err = node.checkOffender(&e)
+	if err != nil {
+		return node.reject(peerId, p2p.Spam, "evidence %s: %s", &e, err)
+	}
+	node.addEvidence(e)
+	return nil
+}
+
+//checkOffender makes sure evidence is against the producer scheduled for the slot, so that keys out of the schedule
+//can't fill pool and blocks with evidence against themselves
+func (node *AkhNode) checkOffender(e *Equivocation) error {
+	slotStart, err := SlotStart(e.First.GetTimestamp(), node.engine.Period(), node.engine.Epsilon())
+	if err != nil {
+		return err
+	}
+	if producer := node.engine.GetProducer(slotStart); producer != e.Offender() {
+		return fmt.Errorf("%s is not the producer of slot %d", e.Offender(), slotStart)
+	}
+	return nil
+}
+
+//addEvidence pools evidence to be included in produced block and gossips it if it is new. Offender is disqualified
+//once block with the evidence is applied, so that every node does it at the same point of the chain.
+func (node *AkhNode) addEvidence(e Equivocation) {
+	if !node.evidence.add(e) {
+		return
+	}
+	log.Warningf("Equivocation of %s pooled: %s\n", e.Offender(), &e)
+	err := node.Host.PublishEvidence(&e)
+	if err != nil {
+		log.Warningf("%s\n", err)
+	}
+}
@@ -0,0 +1,98 @@
+package node
+
+import (
+	. "github.com/alholm/akhcoin/pkg/blockchain"
+)
+
+//Finality tells whether block may still be reverted by switching to another fork
+type Finality int
+
+const (
+	NotInChain Finality = iota
+	Pending
+	Irreversible
+)
+
+func (f Finality) String() string {
+	switch f {
+	case Pending:
+		return "pending"
+	case Irreversible:
+		return "irreversible"
+	default:
+		return "not in chain"
+	}
+}
+
+//Payment is transaction of the chain sent or received by the node with finality of its block
+type Payment struct {
+	Transaction
+	BlockHash string
+	Finality  Finality
+}
+
+//updateFinality moves the last irreversible block to the newest one more than two thirds of its round delegates
+//confirmed by producing it or blocks on top of it
+func (node *AkhNode) updateFinality() {
+	confirmed := make(map[string]bool)
+	for b := node.Head; b != node.irreversible; b = b.Parent {
+		confirmed[b.Signer] = true
+		schedule := node.engine.GetSchedule(b.GetTimestamp())
+		confirmations := 0
+		for _, delegate := range schedule {
+			if confirmed[delegate] {
+				confirmations++
+			}
+		}
+		if 3*confirmations > 2*len(schedule) {
+			log.Debugf("Block %s is irreversible, confirmed by %d of %d delegates\n", b.Hash, confirmations, len(schedule))
+			node.irreversible = b
+			return
+		}
+	}
+}
+
+//LastIrreversible returns the newest block no fork can revert
+func (node *AkhNode) LastIrreversible() *Block {
+	node.Lock()
+	defer node.Unlock()
+	return node.irreversible
+}
+
+//Finality returns finality of the block of current chain
+func (node *AkhNode) Finality(hash string) Finality {
+	node.Lock()
+	defer node.Unlock()
+	pending := true
+	for b := node.Head; b != nil; b = b.Parent {
+		if b == node.irreversible {
+			pending = false
+		}
+		if b.Hash == hash {
+			if pending {
+				return Pending
+			}
+			return Irreversible
+		}
+	}
+	return NotInChain
+}
+
+//Payments returns transactions of the chain sent or received by the node, the newest first
+func (node *AkhNode) Payments() (payments []Payment) {
+	node.Lock()
+	defer node.Unlock()
+	me := node.Host.ID().Pretty()
+	finality := Pending
+	for b := node.Head; b != nil; b = b.Parent {
+		if b == node.irreversible {
+			finality = Irreversible
+		}
+		for _, t := range b.Transactions {
+			if t.Signer == me || t.Recipient == me {
+				payments = append(payments, Payment{t, b.Hash, finality})
+			}
+		}
+	}
+	return
+}
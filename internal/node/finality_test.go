@@ -0,0 +1,41 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/alholm/akhcoin/pkg/consensus"
+)
+
+func TestAkhNode_updateFinality(t *testing.T) {
+	genesis := blockchain.CreateGenesis()
+	node := &AkhNode{
+		engine:       consensus.NewFixedAuthority([]string{"a", "b", "c", "d"}, genesis.GetTimestamp()),
+		Genesis:      genesis,
+		Head:         genesis,
+		irreversible: genesis,
+	}
+
+	expected := []string{"", "", "a", "b", "c"}
+	for i, signer := range []string{"a", "b", "c", "d", "a"} {
+		block := &blockchain.Block{BlockData: blockchain.BlockData{Unit: blockchain.Unit{Signer: signer, Hash: fmt.Sprintf("%s%d", signer, i)}}, Parent: node.Head}
+		node.Head.Next = block
+		node.Head = block
+		node.updateFinality()
+
+		if expected[i] == "" && node.irreversible != genesis || expected[i] != "" && node.irreversible.Signer != expected[i] {
+			t.Fatalf("block %d of %s: last irreversible block is %s, expected one of %s", i, signer, node.irreversible.Hash, expected[i])
+		}
+	}
+
+	if f := node.Finality("b1"); f != Irreversible {
+		t.Errorf("block confirmed by 3 of 4 delegates is %s", f)
+	}
+	if f := node.Finality("d3"); f != Pending {
+		t.Errorf("block confirmed by 2 of 4 delegates is %s", f)
+	}
+	if f := node.Finality("unknown"); f != NotInChain {
+		t.Errorf("unknown block is %s", f)
+	}
+}
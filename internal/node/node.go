@@ -3,8 +3,9 @@ package node
 import (
 	. "github.com/alholm/akhcoin/pkg/blockchain"
 	"github.com/alholm/akhcoin/internal/p2p"
+	"os"
+	"path/filepath"
 	"sync"
-	"time"
 
 	"fmt"
 	"github.com/alholm/akhcoin/pkg/balances"
@@ -17,33 +18,73 @@ import (
 
 var log = logging.Logger("main")
 
+const protectionFileName = "slashing_protection.json"
+
 type AkhNode struct {
 	Host             p2p.AkhHost
 	transactionsPool []Transaction //TODO avoid duplication (can't just use map of T as T has byte arrays which don't define equity
 	votesPool        []Vote
-	poll             *consensus.Poll
+	engine           consensus.Engine
+	clock            consensus.Clock
 	Genesis          *Block
 	Head             *Block
+	headHeight       int64  //number of blocks between head and genesis, kept along with head
+	irreversible     *Block //the last block no fork can revert, see updateFinality
 	balances         *balances.Balances
+	detector         *consensus.EquivocationDetector
+	evidence         *evidencePool
+	protection       *consensus.SlashingProtection
+	snapshots        *stateSnapshots
+	checkpoints      checkpoints
+	late             map[string]int64 //blocks of delegates received late, see observeDelay
 	sync.Mutex
 }
 
 func NewAkhNode(port int, privateKey []byte) (node *AkhNode) {
+	return NewAkhNodeWithClock(port, privateKey, consensus.RealClock)
+}
+
+//NewAkhNodeWithClock creates node producing and validating blocks by the clock given, e.g. manual one in simulations.
+//The clock is corrected by median offset of peers clocks, see p2p.TimeOffsets, by no more than half of epsilon,
+//so that blocks produced by corrected clock stay timely for peers.
+func NewAkhNodeWithClock(port int, privateKey []byte, clock consensus.Clock) (node *AkhNode) {
 	genesis := CreateGenesis()
 	transactionPool := make([]Transaction, 0, 100) //magic constant
 	votesPool := make([]Vote, 0, 100)              //magic constant
 
 	host := p2p.StartHost(port, privateKey, true)
 
+	protection, err := consensus.NewSlashingProtection(filepath.Join(p2p.DataDir(host.ID()), protectionFileName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	engine, err := consensus.NewEngine(genesis.GetTimestamp())
+	if err != nil {
+		log.Fatal(err)
+	}
+	checkpoints, err := loadCheckpoints(host.NetworkName)
+	if err != nil {
+		log.Fatal(err)
+	}
 	node = &AkhNode{
 		transactionsPool: transactionPool,
 		votesPool:        votesPool,
-		poll: consensus.NewPoll(viper.GetInt("poll.MaxDelegates"), viper.GetInt("poll.MaxVotes"),
-			viper.GetDuration("poll.freezePeriod")*time.Second, genesis.GetTimestamp()),
-		Genesis:  genesis,
-		Head:     genesis,
-		balances: balances.NewBalances(),
+		engine:           engine,
+		clock:            consensus.NewAdjustedClock(clock, host.TimeOffsets.Offset, engine.Epsilon()/2),
+		Genesis:          genesis,
+		Head:             genesis,
+		irreversible:     genesis,
+		//votes are weighted by voters balances
+		balances: balances.NewBalances(engine.SetWeight),
 		Host:     host,
+		//conflicting blocks are looked for within two last rounds
+		detector:    consensus.NewEquivocationDetector(engine.Period(), engine.Epsilon(), 2*int64(engine.GetMaxElected())),
+		evidence:    newEvidencePool(),
+		protection:  protection,
+		snapshots:   newStateSnapshots(viper.GetInt("snapshotInterval")),
+		checkpoints: checkpoints,
+		late:        make(map[string]int64),
 	}
 
 	brp := &p2p.BlockStreamHandler{Head: &node.Head}
@@ -58,9 +99,12 @@ func NewAkhNode(port int, privateKey []byte) (node *AkhNode) {
 	vrp := &p2p.VoteStreamHandler{ProcessResult: node.ReceiveVote}
 	host.AddStreamHandler(vrp)
 
+	erp := &p2p.EvidenceStreamHandler{ProcessResult: node.ReceiveEvidence}
+	host.AddStreamHandler(erp)
+
 	host.DiscoverPeers()
 
-	ttpChan := consensus.StartProduction(node.poll, node.Host.ID().Pretty())
+	ttpChan := consensus.StartProduction(node.engine, node.clock, node.Host.ID().Pretty())
 
 	go func() {
 		for range ttpChan {
@@ -82,26 +126,25 @@ func NewAkhNode(port int, privateKey []byte) (node *AkhNode) {
 //Check whether transaction was created during current production period
 //TODO Txns created at the end of production period may get lost
 func (node *AkhNode) timeValid(s Signable) bool {
-	currentTimeStamp := GetTimeStamp()
-	currentSlotStart := node.poll.GetCurrentSlotStart(currentTimeStamp)
-	return s.GetTimestamp() > currentSlotStart && s.GetTimestamp() < currentTimeStamp
+	currentTimeStamp := node.clock.Now()
+	currentSlotStart := node.engine.GetCurrentSlotStart(currentTimeStamp)
+	return s.GetTimestamp() >= currentSlotStart && s.GetTimestamp() <= currentTimeStamp
 }
 
-func (node *AkhNode) ReceiveTransaction(t Transaction) {
+func (node *AkhNode) ReceiveTransaction(t Transaction, peerId peer.ID) error {
 	verified, err := t.Verify()
 	timeValid := node.timeValid(&t)
 
 	log.Debugf("Txn received: %s, Verified=%t, time valid: %t\n", &t, verified, timeValid)
-	if err != nil {
-		log.Warningf("Invalid transaction received: %s\n", err)
-		return
+	if !verified {
+		return node.reject(peerId, p2p.InvalidSignature, "invalid transaction %s: %s", &t, err)
 	}
 	if !timeValid {
-		log.Warningf("Transaction received at wrong time")
-		return
+		return node.decline(peerId, "transaction %s received at wrong time", &t)
 	}
 
 	node.addTransactionToPool(t)
+	return nil
 }
 
 //TODO synchronize
@@ -114,42 +157,47 @@ func (node *AkhNode) addVoteToPool(v Vote) {
 	node.votesPool = append(node.votesPool, v)
 }
 
-//TODO think of reaction to invalid block
 //TODO retransmit valid block
-func (node *AkhNode) Receive(bd BlockData, peerId peer.ID) {
+func (node *AkhNode) Receive(bd BlockData, peerId peer.ID) error {
 	node.Lock()
 	defer node.Unlock()
 
 	if bd.Hash == node.Head.Hash {
-		return
+		return nil
 	}
 
-	//in case we've just joined network we have no option but to trust first block we received is valid
-	//and download whole chain from peer sent it.
-	//Known attack here is fraud producer will change number of votes in the block, so that poll state will differ from
-	//correct one and node will decline true blocks. But in this case the chain block is on may consist only of blocks
-	//produced by that single misbehaved producer, which will become visible soon.
-
-	if node.Head != node.Genesis {
-		//filter outdated and misproduced blocks
-		valid, err := node.poll.IsValid(&bd, GetTimeStamp())
-
-		log.Debugf("Block received: %s, valid: %v\n", bd.Hash, valid)
-		if !valid {
-			log.Error(err)
-			return
-		}
+	//block of another chain, e.g. when we've just joined network, may belong to the round engine knows nothing about,
+	//so that only its time is checked. The chain is downloaded from the peer sent it, every block of it is validated
+	//against the schedule derived from the blocks before it when attached.
+	receivedAt := node.clock.Now()
+	valid, err := node.engine.IsTimely(&bd, receivedAt)
+	if !valid {
+		return node.decline(peerId, "block %s: %s", bd.Hash, err)
+	}
+	inSlot, slotErr := node.engine.IsInSlot(&bd)
+	if inSlot {
+		//headers of scheduled producers only, so that forged block of a future slot doesn't make real ones forgotten
+		node.observe(&bd)
+	}
+	log.Debugf("Block received: %s, in slot: %v\n", bd.Hash, inSlot)
+	if bd.ParentHash == node.Head.Hash && !inSlot {
+		//filter misproduced blocks
+		return node.reject(peerId, p2p.WrongSlot, "block %s: %s", bd.Hash, slotErr)
 	}
 	if bd.ParentHash == node.Head.Hash {
-		node.attach(bd)
-	} else {
-		//switch to the longest chain if there is one, decline otherwise
-		node.switchToLongest(bd, peerId)
+		err = node.attach(bd)
+		if err != nil {
+			return node.reject(peerId, p2p.InvalidBlock, "block %s: %s", bd.Hash, err)
+		}
+		node.observeDelay(bd, receivedAt)
+		return nil
 	}
+	//switch to the longest chain if there is one, decline otherwise
+	return node.switchToLongest(bd, peerId)
 }
 
 //See Node_test for scenarios handled
-func (node *AkhNode) switchToLongest(forkTip BlockData, peerId peer.ID) {
+func (node *AkhNode) switchToLongest(forkTip BlockData, peerId peer.ID) (err error) {
 	myForkLen := 0
 	hisForkLen := 0
 
@@ -159,7 +207,6 @@ func (node *AkhNode) switchToLongest(forkTip BlockData, peerId peer.ID) {
 	for {
 
 		for hisBlock.GetTimestamp() > myBlock.GetTimestamp() {
-			var err error
 			hisBlock, err = node.getParent(hisBlock, peerId)
 			if err != nil {
 				log.Error(err)
@@ -167,16 +214,21 @@ func (node *AkhNode) switchToLongest(forkTip BlockData, peerId peer.ID) {
 			}
 			_, err = node.isValidForkElement(hisBlock, forkTip)
 			if err != nil {
-				log.Error(err)
-				return
+				return node.reject(peerId, p2p.InvalidBlock, "fork with tip %s: %s", forkTip.Hash, err)
 			}
 
 			hisForkLen++
 		}
 
+		//fork started before the last irreversible block, nothing to download more
+		if hisBlock.GetTimestamp() < node.irreversible.GetTimestamp() {
+			err = fmt.Errorf("fork with tip %s reverts irreversible block %s", forkTip.Hash, node.irreversible.Hash)
+			log.Warningf("%s\n", err)
+			return
+		}
+
 		for myBlock.GetTimestamp() > hisBlock.GetTimestamp() && myBlock != node.Genesis {
 			myBlock = myBlock.Parent
-			//TODO revert block transactions
 			myForkLen++
 		}
 
@@ -187,23 +239,47 @@ func (node *AkhNode) switchToLongest(forkTip BlockData, peerId peer.ID) {
 		}
 	}
 	if myForkLen >= hisForkLen { //we are on the longest chain
+		err = fmt.Errorf("fork with tip %s is not longer than ours: %d blocks against %d", forkTip.Hash, hisForkLen, myForkLen)
+		log.Debugf("%s\n", err)
 		return
 	}
+	//long alternative chain built with old delegates keys is stopped here, before anything is disconnected
+	for b, h := hisBlock.Next, node.headHeight-int64(myForkLen)+1; b != nil; b, h = b.Next, h+1 {
+		err = node.checkpoints.verify(h, b.Hash)
+		if err != nil {
+			return node.reject(peerId, p2p.InvalidBlock, "fork with tip %s: %s", forkTip.Hash, err)
+		}
+	}
 
-	originalHead := node.Head
-	node.Head = myBlock
-	//TODO reconstruct poll and accounts state at this block
+	original := chainSegment(myBlock, node.Head)
+	irreversible := node.irreversible
+	err = node.disconnect(myBlock)
+	if err != nil {
+		//head is moved to the fork start even if state failed to rebuild, original blocks are put back on it
+		err = fmt.Errorf("couldn't switch to fork with tip %s: %s", forkTip.Hash, err)
+		log.Error(err)
+		node.reconnect(original)
+		node.irreversible = irreversible
+		return
+	}
 	for hisBlock.Next != nil {
-		err := node.attach(hisBlock.Next.BlockData)
+		//engine state is the one of the fork at this point, so block is checked against schedule of the fork
+		_, err = node.engine.IsInSlot(&hisBlock.Next.BlockData)
+		if err == nil {
+			err = node.attach(hisBlock.Next.BlockData)
+		}
 		if err != nil {
-			log.Errorf("Couldn't switch to fork with tip %s: block %s invalid: %s", forkTip.Hash, hisBlock.Next.BlockData.Hash, err)
-			node.Head = originalHead
-			//TODO reconstruct poll and balances
+			err = fmt.Errorf("couldn't switch to fork with tip %s: block %s invalid: %s", forkTip.Hash, hisBlock.Next.BlockData.Hash, err)
+			log.Error(err)
+			node.disconnect(myBlock)
+			node.reconnect(original)
+			node.irreversible = irreversible
 			return
 		}
 		hisBlock = hisBlock.Next
 	}
 	node.adjustPools(forkTip)
+	return
 }
 
 func (node *AkhNode) getParent(block *Block, peerId peer.ID) (parent *Block, err error) {
@@ -217,14 +293,14 @@ func (node *AkhNode) getParent(block *Block, peerId peer.ID) (parent *Block, err
 }
 
 func (node *AkhNode) isValidForkElement(block *Block, forkTip BlockData) (valid bool, err error) {
-	if block.Next.ParentHash != block.Hash || block.Next.GetTimestamp()-block.GetTimestamp() < node.poll.Period()-consensus.Epsilon {
+	if block.Next.ParentHash != block.Hash || block.Next.GetTimestamp()-block.GetTimestamp() < node.engine.Period()-node.engine.Epsilon() {
 		err = fmt.Errorf("invalid parent in incoming fork, block: %s", block.Hash)
 		return
 	}
 
 	if block.Signer == forkTip.Signer {
-		roundDuration := node.poll.Period() * int64(node.poll.GetMaxElected())
-		if forkTip.GetTimestamp()-block.GetTimestamp() < roundDuration-consensus.Epsilon {
+		roundDuration := node.engine.Period() * int64(node.engine.GetMaxElected())
+		if forkTip.GetTimestamp()-block.GetTimestamp() < roundDuration-node.engine.Epsilon() {
 			err = fmt.Errorf("potential fraud: fork received from %s with block produced not in order", block.Signer)
 			return
 		}
@@ -241,6 +317,25 @@ func (node *AkhNode) attach(bd BlockData) (err error) {
 		log.Error(err)
 		return
 	}
+	verified, err = bd.VerifyEvidence(node.engine.Period(), node.engine.Epsilon())
+	if !verified {
+		log.Error(err)
+		return
+	}
+	for _, e := range bd.Evidence {
+		err = node.checkOffender(&e)
+		if err != nil {
+			err = fmt.Errorf("block %s evidence %s: %s", bd.Hash, &e, err)
+			log.Error(err)
+			return
+		}
+	}
+
+	err = node.checkpoints.verify(node.headHeight+1, bd.Hash)
+	if err != nil {
+		log.Error(err)
+		return
+	}
 
 	err = node.updateBalances(bd)
 	if err != nil {
@@ -249,6 +344,15 @@ func (node *AkhNode) attach(bd BlockData) (err error) {
 	block := &Block{BlockData: bd, Parent: node.Head}
 	node.Head.Next = block
 	node.Head = block
+	node.headHeight++
+	node.snapshots.take(node)
+	node.updateFinality()
+
+	for _, e := range bd.Evidence {
+		node.addEvidence(e)
+	}
+	node.evidence.included(bd.Evidence)
+	node.evidence.prune(node.irreversible.GetTimestamp())
 
 	node.adjustPools(bd)
 	return
@@ -265,14 +369,27 @@ func (node *AkhNode) updateBalances(bd BlockData) (err error) {
 		return fmt.Errorf("block %s contains incorrect transactions from balances perspective", bd.Hash)
 	}
 
-	for _, t := range bd.Transactions {
-		err := node.balances.Submit(t)
+	//block votes are counted with weights voters had before the block
+	node.engine.ApplyBlock(bd)
+
+	for i, t := range bd.Transactions {
+		err = node.balances.Submit(t)
 		if err != nil {
+			//nothing of the block is left applied
+			for j := i - 1; j >= 0; j-- {
+				node.balances.Revert(bd.Transactions[j])
+			}
+			node.balances.Sync()
+			if revertErr := node.engine.RevertBlock(bd); revertErr != nil {
+				log.Errorf("Failed to revert block %s: %s\n", bd.Hash, revertErr)
+			}
 			return fmt.Errorf("invalid block transaction: %s: %s", &t, err)
 		}
 	}
 
 	node.balances.SubmitReward(bd.Signer, bd.Reward)
+	//voters weights changed by the block get to the engine before the next block is applied
+	node.balances.Sync()
 	return
 }
 
@@ -293,27 +410,22 @@ func (node *AkhNode) adjustPools(bd BlockData) {
 	node.votesPool = node.votesPool[:0]
 }
 
-func (node *AkhNode) ReceiveVote(v Vote) {
+func (node *AkhNode) ReceiveVote(v Vote, peerId peer.ID) error {
 	verified, err := v.Verify()
 	timeValid := node.timeValid(&v)
 
 	log.Debugf("Vote received: %s, Verified=%t, time valid: %t\n", &v, verified, timeValid)
-	if err != nil {
-		log.Warningf("Invalid vote received: %s\n", err)
-		return
+	if !verified {
+		return node.reject(peerId, p2p.InvalidSignature, "invalid vote %s: %s", &v, err)
 	}
 
 	if !timeValid {
-		log.Warningf("Vote received at wrong time")
-		return
-	}
-
-	err = node.poll.SubmitVote(v)
-	if err != nil {
-		log.Errorf("Failed to submit vote: %s\n", err)
+		return node.decline(peerId, "vote %s received at wrong time", &v)
 	}
 
+	//vote counts only when it gets to the chain, see attach
 	node.addVoteToPool(v)
+	return nil
 }
 
 func (node *AkhNode) Produce() (block *Block, err error) {
@@ -322,7 +434,15 @@ func (node *AkhNode) Produce() (block *Block, err error) {
 	txnsPool := node.balances.CollectValidTxns(node.transactionsPool, true)
 	votesPool := node.votesPool
 	privateKey := node.Host.Peerstore().PrivKey(node.Host.ID())
-	block = NewBlock(privateKey, node.Head, txnsPool, votesPool)
+	//never sign second block for the same slot, whatever clock or standby node says
+	timeStamp := node.clock.Now()
+	slotStart, _ := SlotStart(timeStamp, node.engine.Period(), node.engine.Epsilon())
+	err = node.protection.CheckAndRecord(node.Host.ID().Pretty(), slotStart/node.engine.Period())
+	if err != nil {
+		log.Errorf("%s\n", err)
+		return
+	}
+	block = NewBlockAt(privateKey, node.Head, timeStamp, txnsPool, votesPool, node.evidence.getPending()...)
 	//TODO ineffective: excess verification
 	node.attach(block.BlockData)
 
@@ -331,9 +451,16 @@ func (node *AkhNode) Produce() (block *Block, err error) {
 	return
 }
 
+//height returns number of blocks between block and genesis walking the whole chain, node keeps it for head, see headHeight
+func height(block *Block) (h int64) {
+	for b := block; b.Parent != nil; b = b.Parent {
+		h++
+	}
+	return
+}
+
 func (node *AkhNode) Announce(block *Block) (err error) {
-	node.Host.PublishBlock(block)
-	return nil
+	return node.Host.PublishBlock(block)
 }
 
 func (node *AkhNode) GetPrivate() crypto.PrivKey {
@@ -348,25 +475,57 @@ func (node *AkhNode) Pay(peerIdStr string, amount uint64) error {
 	}
 
 	private := node.GetPrivate()
-	t := Pay(private, peerId, amount)
-
-	node.Host.PublishTransaction(t)
-	node.ReceiveTransaction(*t)
+	t := PayAt(private, peerId, amount, node.clock.Now())
 
-	return nil
+	err = node.Host.PublishTransaction(t)
+	if err != nil {
+		log.Warningf("%s\n", err)
+	}
+	return node.ReceiveTransaction(*t, node.Host.ID())
 }
 
 func (node *AkhNode) Vote(peerIdStr string) error {
+	return node.vote(peerIdStr, NewVoteAt)
+}
+
+//Withdraw cancels node's standing vote for the candidate
+func (node *AkhNode) Withdraw(peerIdStr string) error {
+	return node.vote(peerIdStr, NewWithdrawalAt)
+}
+
+//vote creates vote by the node clock, so that it is timely for the node itself and its peers
+func (node *AkhNode) vote(peerIdStr string, newVote func(crypto.PrivKey, peer.ID, int64) *Vote) error {
 
 	peerId, err := peer.IDB58Decode(peerIdStr)
 	if err != nil {
 		return err
 	}
 
-	vote := NewVote(node.GetPrivate(), peerId)
+	vote := newVote(node.GetPrivate(), peerId, node.clock.Now())
 
-	node.Host.PublishVote(vote)
-	node.ReceiveVote(*vote)
+	err = node.Host.PublishVote(vote)
+	if err != nil {
+		log.Warningf("%s\n", err)
+	}
+	return node.ReceiveVote(*vote, node.Host.ID())
+}
 
-	return nil
+//ExportProtection writes slashing protection records to the file to be imported on another machine
+func (node *AkhNode) ExportProtection(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return node.protection.Export(file)
+}
+
+//ImportProtection merges slashing protection records exported on another machine
+func (node *AkhNode) ImportProtection(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return node.protection.Import(file)
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/alholm/akhcoin/pkg/consensus"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-crypto"
+	"github.com/libp2p/go-libp2p-peer"
 	"github.com/spf13/viper"
 	"testing"
 	"time"
@@ -12,15 +13,18 @@ import (
 
 func TestAkhNode_switchToLongest(t *testing.T) {
 
-	viper.Set("poll.period", int64(50*time.Millisecond))
+	period := int64(50 * time.Millisecond)
+	viper.Set("poll.period", period)
 	viper.Set("poll.epsilon", int64(1*time.Millisecond))
 	viper.Set("poll.maxDelegates", 3)
 
-	var nodes [3]*AkhNode
-
-	for i := 0; i < 3; i++ {
-		nodes[i] = startRandomNode(9654 + i)
-	}
+	//slots are passed by advancing the clock, not waited for. Clock starts at the 3rd slot of a round, so that nodes
+	//produce in their turns below
+	roundDuration := 3 * period
+	genesisStart := blockchain.CreateGenesis().GetTimestamp()
+	now := blockchain.GetTimeStamp()
+	clock := consensus.NewManualClock(now - (now-genesisStart)%roundDuration + roundDuration + 2*period)
+	nodes := startNodes(9654, 3, clock)
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -37,27 +41,30 @@ func TestAkhNode_switchToLongest(t *testing.T) {
 	forkStart, _ := nodes[2].Produce()
 	nodes[0].attach(forkStart.BlockData)
 	nodes[1].attach(forkStart.BlockData)
+	clock.Advance(50 * time.Millisecond)
 
 	//1
 	nodes[0].Produce()
-	time.Sleep(50 * time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
 
 	//2
 	b1, _ := nodes[1].Produce()
 	nodes[2].attach(b1.BlockData)
-	time.Sleep(50 * time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
 
 	//3
 	b2, _ := nodes[2].Produce()
 	nodes[1].attach(b2.BlockData)
-	time.Sleep(50 * time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
 
 	//1
 	b3, _ := nodes[0].Produce()
-	time.Sleep(50 * time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
 
 	//attempt to convince others to switch to minor fork
-	nodes[1].switchToLongest(b3.BlockData, nodes[0].Host.ID())
+	if err := nodes[1].switchToLongest(b3.BlockData, nodes[0].Host.ID()); err == nil {
+		t.Error("shorter fork not declined")
+	}
 
 	//2
 	forkEnd, _ := nodes[1].Produce()
@@ -89,7 +96,7 @@ func TestAkhNode_switchToLongest(t *testing.T) {
 
 	//3 - in parallel with 2
 	nodes[2].Produce()
-	time.Sleep(50 * time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
 	forkEnd, _ = nodes[2].Produce()
 	nodes[1].switchToLongest(forkEnd.BlockData, nodes[2].Host.ID())
 
@@ -110,13 +117,10 @@ func TestInitialBlockDownload(t *testing.T) {
 	viper.Set("poll.epsilon", int64(10*time.Millisecond))
 	viper.Set("poll.maxDelegates", 3)
 
-	var nodes [3]*AkhNode
-	for i := 0; i < 3; i++ {
-		nodes[i] = startRandomNode(10765 + i)
-	}
+	nodes := startNodes(10765, 3, consensus.RealClock)
 	time.Sleep(200 * time.Millisecond) //waiting for mdns
 
-	time.Sleep(consensus.UntilNext(period))
+	time.Sleep(consensus.UntilNext(consensus.RealClock, period))
 	time.Sleep(200 * time.Millisecond)
 	nodes[1].Pay(nodes[0].Host.ID().Pretty(), 42)
 	nodes[2].Pay(nodes[1].Host.ID().Pretty(), 24)
@@ -127,7 +131,7 @@ func TestInitialBlockDownload(t *testing.T) {
 		t.Errorf("%d transactions in pull, has to be 2", l)
 	}
 
-	time.Sleep(consensus.UntilNext(period))
+	time.Sleep(consensus.UntilNext(consensus.RealClock, period))
 	time.Sleep(30 * time.Millisecond)
 	nodes[0].Vote(nodes[1].Host.ID().Pretty())
 	time.Sleep(30 * time.Millisecond)
@@ -142,16 +146,19 @@ func TestInitialBlockDownload(t *testing.T) {
 		t.Fatalf("%d votes in pull, has to be 3", l)
 	}
 
-	time.Sleep(consensus.UntilNext(period))
+	time.Sleep(consensus.UntilNext(consensus.RealClock, period))
 
 	time.Sleep(time.Duration(2 * period)) //3 blocks produced
 
-	newNode := startRandomNode(10765 + 3)
+	newNode := startRandomNode(10765+3, consensus.RealClock)
 
 	time.Sleep(100 * time.Millisecond)
-	time.Sleep(consensus.UntilNext(period)) //4th block produced
+	time.Sleep(consensus.UntilNext(consensus.RealClock, period)) //4th block produced
 	time.Sleep(100 * time.Millisecond)
 
+	if height(newNode.Head) == 0 {
+		t.Error("no blocks produced")
+	}
 	for i := 0; i < 3; i++ {
 		if nodes[i].Head.Hash != newNode.Head.Hash {
 			t.Fail()
@@ -164,9 +171,74 @@ func TestInitialBlockDownload(t *testing.T) {
 	}
 }
 
-func startRandomNode(p int) *AkhNode {
+//TestAkhNode_syncOverBurst checks chain longer than rate limit burst is downloaded block by block without penalties
+func TestAkhNode_syncOverBurst(t *testing.T) {
+	period := int64(50 * time.Millisecond)
+	viper.Set("poll.period", period)
+	viper.Set("poll.epsilon", int64(1*time.Millisecond))
+	viper.Set("poll.maxDelegates", 1)
+	viper.Set("p2p.rateLimit.burst", 2)
+	defer viper.Set("p2p.rateLimit.burst", 20)
+	defer viper.Set("poll.maxDelegates", 3)
+
+	genesisStart := blockchain.CreateGenesis().GetTimestamp()
+	now := blockchain.GetTimeStamp()
+	clock := consensus.NewManualClock(now - (now-genesisStart)%period + period)
+	producer := startNodes(11765, 1, clock)[0]
+
+	//the only delegate produces in every slot
+	blocksN := int64(10)
+	for h := int64(1); h <= blocksN; h++ {
+		clock.WaitSleepers(1)
+		clock.Advance(time.Duration(period))
+		for producer.headHeight < h {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	newNode := startRandomNode(11766, clock)
+	time.Sleep(200 * time.Millisecond) //waiting for mdns
+
+	err := newNode.switchToLongest(producer.Head.BlockData, producer.Host.ID())
+	if err != nil {
+		t.Fatalf("chain of %d blocks not downloaded: %s", blocksN, err)
+	}
+	if newNode.Head.Hash != producer.Head.Hash {
+		t.Errorf("head %s differs from producer's one %s", newNode.Head.Hash, producer.Head.Hash)
+	}
+	if newNode.headHeight != blocksN || producer.headHeight != blocksN {
+		t.Errorf("head heights %d and %d, expected %d", newNode.headHeight, producer.headHeight, blocksN)
+	}
+	if score := producer.Host.Scores.Score(newNode.Host.ID()); score != 0 {
+		t.Errorf("syncing node penalized, score: %d", score)
+	}
+
+	producer.Host.Close()
+	newNode.Host.Close()
+}
+
+func startRandomNode(p int, clock consensus.Clock) *AkhNode {
 	private, _, _ := blockchain.NewKeys()
 	privateBytes, _ := crypto.MarshalPrivateKey(private)
-	node := NewAkhNode(p, privateBytes)
+	node := NewAkhNodeWithClock(p, privateBytes, clock)
 	return node
 }
+
+//startNodes starts n nodes on ports from p on, they are genesis delegates of the network in the order started
+func startNodes(p int, n int, clock consensus.Clock) []*AkhNode {
+	keys := make([][]byte, n)
+	ids := make([]string, n)
+	for i := range keys {
+		private, public, _ := blockchain.NewKeys()
+		keys[i], _ = crypto.MarshalPrivateKey(private)
+		id, _ := peer.IDFromPublicKey(public)
+		ids[i] = id.Pretty()
+	}
+	viper.Set("poll.genesisDelegates", ids)
+
+	nodes := make([]*AkhNode, n)
+	for i := range nodes {
+		nodes[i] = NewAkhNodeWithClock(p+i, keys[i], clock)
+	}
+	return nodes
+}
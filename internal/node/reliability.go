@@ -0,0 +1,35 @@
+package node
+
+import (
+	. "github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/alholm/akhcoin/pkg/consensus"
+)
+
+//DelegateStats counts slots delegate was scheduled for since engine skipped it last time, see Engine.GetStats
+type DelegateStats struct {
+	consensus.ProductionStats
+	Late int64 //blocks received later than Epsilon after their timestamp since node start
+}
+
+//observeDelay counts block as late if it was received later than producer's clock and network delay allow
+func (node *AkhNode) observeDelay(bd BlockData, receivedAt int64) {
+	if receivedAt-bd.GetTimestamp() > node.engine.Epsilon() {
+		node.late[bd.Signer]++
+	}
+}
+
+//Reliability returns stats of delegates the engine judges their reliability by, along with blocks observed late
+func (node *AkhNode) Reliability() map[string]DelegateStats {
+	node.Lock()
+	defer node.Unlock()
+	stats := make(map[string]DelegateStats)
+	for id, production := range node.engine.GetStats() {
+		stats[id] = DelegateStats{ProductionStats: production}
+	}
+	for id, late := range node.late {
+		s := stats[id]
+		s.Late = late
+		stats[id] = s
+	}
+	return stats
+}
@@ -0,0 +1,142 @@
+package node
+
+import (
+	"fmt"
+
+	. "github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/alholm/akhcoin/pkg/consensus"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("snapshotInterval", 100)
+}
+
+//keptSnapshots is number of the last state snapshots node keeps
+const keptSnapshots = 10
+
+//stateSnapshot is engine and balances state after the block
+type stateSnapshot struct {
+	engine   consensus.EngineState
+	balances map[string]uint64
+}
+
+//stateSnapshots keeps state after some of the last attached blocks, so that the chain is replayed from the closest
+//of them instead of genesis
+type stateSnapshots struct {
+	byHash    map[string]stateSnapshot
+	order     []string
+	interval  int
+	sinceLast int
+}
+
+func newStateSnapshots(interval int) *stateSnapshots {
+	return &stateSnapshots{byHash: make(map[string]stateSnapshot), interval: interval}
+}
+
+//take snapshots node state after its head block every interval blocks
+func (s *stateSnapshots) take(node *AkhNode) {
+	s.sinceLast++
+	if s.interval <= 0 || s.sinceLast < s.interval {
+		return
+	}
+	s.sinceLast = 0
+	s.byHash[node.Head.Hash] = stateSnapshot{node.engine.Snapshot(), node.balances.Snapshot()}
+	s.order = append(s.order, node.Head.Hash)
+	if len(s.order) > keptSnapshots {
+		delete(s.byHash, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+//applyState applies block to engine and balances without verifying it again
+func (node *AkhNode) applyState(bd BlockData) error {
+	return node.updateBalances(bd)
+}
+
+//revertBalances cancels block transactions and reward
+func (node *AkhNode) revertBalances(bd BlockData) {
+	node.balances.RevertReward(bd.Signer, bd.Reward)
+	for i := len(bd.Transactions) - 1; i >= 0; i-- {
+		node.balances.Revert(bd.Transactions[i])
+	}
+	node.balances.Sync()
+}
+
+//rebuild replays the chain up to the block from the closest snapshot or genesis
+func (node *AkhNode) rebuild(to *Block) (err error) {
+	from := to
+	snapshot, ok := node.snapshots.byHash[from.Hash]
+	for !ok && from != node.Genesis {
+		from = from.Parent
+		snapshot, ok = node.snapshots.byHash[from.Hash]
+	}
+	if ok {
+		node.engine.Restore(snapshot.engine)
+		node.balances.Restore(snapshot.balances)
+	} else {
+		node.engine.Reset()
+		node.balances.Restore(map[string]uint64{})
+	}
+
+	blocks := chainSegment(from, to)
+	log.Infof("Replaying %d blocks from %s to %s\n", len(blocks), from.Hash, to.Hash)
+	for _, b := range blocks {
+		err = node.applyState(b.BlockData)
+		if err != nil {
+			return fmt.Errorf("failed to replay block %s: %s", b.Hash, err)
+		}
+	}
+	return
+}
+
+//Reindex drops state snapshots and replays the whole chain from genesis
+func (node *AkhNode) Reindex() error {
+	node.Lock()
+	defer node.Unlock()
+	node.snapshots = newStateSnapshots(node.snapshots.interval)
+	return node.rebuild(node.Head)
+}
+
+//chainSegment returns blocks after from up to to inclusive in chain order
+func chainSegment(from, to *Block) (blocks []*Block) {
+	for b := to; b != from; b = b.Parent {
+		blocks = append(blocks, b)
+	}
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return
+}
+
+//disconnect moves head back to the block reverting disconnected blocks, chain is replayed if they are too many
+func (node *AkhNode) disconnect(to *Block) (err error) {
+	for b := node.Head; b != to; b = b.Parent {
+		err = node.engine.RevertBlock(b.BlockData)
+		if err != nil {
+			log.Infof("Can't revert block %s: %s\n", b.Hash, err)
+			//chain is replayed anyway, so the height is counted again too
+			node.Head, node.headHeight = to, height(to)
+			return node.rebuild(to)
+		}
+		node.revertBalances(b.BlockData)
+		node.headHeight--
+	}
+	node.Head = to
+	return
+}
+
+//reconnect puts back blocks disconnected from the head, they were validated when attached first
+func (node *AkhNode) reconnect(blocks []*Block) {
+	for _, b := range blocks {
+		err := node.applyState(b.BlockData)
+		if err != nil {
+			log.Errorf("Failed to reconnect block %s: %s\n", b.Hash, err)
+		}
+		b.Parent.Next = b
+	}
+	if len(blocks) > 0 {
+		node.Head = blocks[len(blocks)-1]
+		node.headHeight += int64(len(blocks))
+	}
+}
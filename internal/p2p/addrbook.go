@@ -0,0 +1,217 @@
+package p2p
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const addrBookFileName = "addrbook.json"
+
+//AddrBookEntry is what node remembers about a peer between restarts
+type AddrBookEntry struct {
+	ID        string
+	Addrs     []string
+	LastSeen  time.Time
+	LastTried time.Time
+	Connected bool //whether last connection attempt succeeded
+	Failures  int  //number of failed connection attempts in a row
+}
+
+//AddrBook is persistent storage of peers addresses, kept in node data directory
+type AddrBook struct {
+	entries map[peer.ID]*AddrBookEntry
+	path    string
+	stop    chan struct{}
+	sync.Mutex
+}
+
+func NewAddrBook(dataDir string) *AddrBook {
+	b := &AddrBook{
+		entries: make(map[peer.ID]*AddrBookEntry),
+		path:    filepath.Join(dataDir, addrBookFileName),
+		stop:    make(chan struct{}),
+	}
+	err := b.load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Warningf("Failed to load address book from %s: %s\n", b.path, err)
+	}
+	return b
+}
+
+//Add merges peer addresses into the book and marks peer as seen now
+func (b *AddrBook) Add(peerInfo ps.PeerInfo) {
+	b.Lock()
+	defer b.Unlock()
+	entry := b.entry(peerInfo.ID)
+	for _, addr := range peerInfo.Addrs {
+		if !containsString(entry.Addrs, addr.String()) {
+			entry.Addrs = append(entry.Addrs, addr.String())
+		}
+	}
+	entry.LastSeen = time.Now()
+}
+
+//MarkAttempt records result of connection attempt to the peer
+func (b *AddrBook) MarkAttempt(id peer.ID, err error) {
+	b.Lock()
+	defer b.Unlock()
+	entry := b.entry(id)
+	entry.LastTried = time.Now()
+	entry.Connected = err == nil
+	if entry.Connected {
+		entry.LastSeen = entry.LastTried
+		entry.Failures = 0
+	} else {
+		entry.Failures++
+	}
+}
+
+func (b *AddrBook) Remove(id peer.ID) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.entries, id)
+}
+
+func (b *AddrBook) Get(id peer.ID) (entry AddrBookEntry, ok bool) {
+	b.Lock()
+	defer b.Unlock()
+	e, ok := b.entries[id]
+	if ok {
+		entry = *e
+	}
+	return
+}
+
+func (b *AddrBook) entry(id peer.ID) *AddrBookEntry {
+	entry, ok := b.entries[id]
+	if !ok {
+		entry = &AddrBookEntry{ID: id.Pretty()}
+		b.entries[id] = entry
+	}
+	return entry
+}
+
+//PeerInfos returns known peers, the ones connected last time and seen recently go first
+func (b *AddrBook) PeerInfos() (peerInfos []ps.PeerInfo) {
+	b.Lock()
+	entries := make([]AddrBookEntry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		entries = append(entries, *entry)
+	}
+	b.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Connected != entries[j].Connected {
+			return entries[i].Connected
+		}
+		return entries[i].LastSeen.After(entries[j].LastSeen)
+	})
+
+	for _, entry := range entries {
+		peerInfo, err := entry.peerInfo()
+		if err != nil {
+			log.Warningf("Skipping address book entry %s: %s\n", entry.ID, err)
+			continue
+		}
+		peerInfos = append(peerInfos, peerInfo)
+	}
+	return
+}
+
+func (entry *AddrBookEntry) peerInfo() (peerInfo ps.PeerInfo, err error) {
+	peerInfo.ID, err = peer.IDB58Decode(entry.ID)
+	if err != nil {
+		return
+	}
+	for _, addrStr := range entry.Addrs {
+		addr, addrErr := ma.NewMultiaddr(addrStr)
+		if addrErr != nil {
+			continue
+		}
+		peerInfo.Addrs = append(peerInfo.Addrs, addr)
+	}
+	return
+}
+
+func (b *AddrBook) Save() (err error) {
+	b.Lock()
+	entries := make([]AddrBookEntry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		entries = append(entries, *entry)
+	}
+	b.Unlock()
+
+	bytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return
+	}
+	tmpPath := b.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, bytes, 0644)
+	if err != nil {
+		return
+	}
+	return os.Rename(tmpPath, b.path)
+}
+
+func (b *AddrBook) load() (err error) {
+	bytes, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return
+	}
+	var entries []AddrBookEntry
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil {
+		return
+	}
+	for i := range entries {
+		id, decErr := peer.IDB58Decode(entries[i].ID)
+		if decErr != nil {
+			log.Warningf("Skipping address book entry %s: %s\n", entries[i].ID, decErr)
+			continue
+		}
+		b.entries[id] = &entries[i]
+	}
+	return
+}
+
+//startSaving saves address book every period until Close is called
+func (b *AddrBook) startSaving(period time.Duration) {
+	ticker := time.NewTicker(period)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := b.Save()
+				if err != nil {
+					log.Warningf("Failed to save address book: %s\n", err)
+				}
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (b *AddrBook) Close() error {
+	close(b.stop)
+	return b.Save()
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
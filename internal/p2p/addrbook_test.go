@@ -0,0 +1,55 @@
+package p2p
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/libp2p/go-libp2p-peer"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrBook_Save(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "akhaddrbook")
+	defer os.RemoveAll(dir)
+
+	ids := make([]peer.ID, 3)
+	book := NewAddrBook(dir)
+	for i := range ids {
+		_, public, _ := blockchain.NewKeys()
+		ids[i], _ = peer.IDFromPublicKey(public)
+		addr1, _ := ma.NewMultiaddr(fmt.Sprintf("/ip4/10.0.0.%d/tcp/9765", i))
+		addr2, _ := ma.NewMultiaddr(fmt.Sprintf("/ip6/::%d/tcp/9765", i))
+		book.Add(ps.PeerInfo{ID: ids[i], Addrs: []ma.Multiaddr{addr1}})
+		book.Add(ps.PeerInfo{ID: ids[i], Addrs: []ma.Multiaddr{addr1, addr2}})
+	}
+	book.MarkAttempt(ids[0], fmt.Errorf("connection refused"))
+	book.MarkAttempt(ids[1], nil)
+
+	err := book.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewAddrBook(dir)
+	peerInfos := restored.PeerInfos()
+	if len(peerInfos) != 3 {
+		t.Fatalf("%d peers restored, expected 3", len(peerInfos))
+	}
+	if peerInfos[0].ID != ids[1] {
+		t.Errorf("connected peer is not first: %s", peerInfos[0].ID.Pretty())
+	}
+	for _, peerInfo := range peerInfos {
+		if len(peerInfo.Addrs) != 2 {
+			t.Errorf("peer %s has %d addresses, expected 2", peerInfo.ID.Pretty(), len(peerInfo.Addrs))
+		}
+	}
+
+	entry, _ := restored.Get(ids[0])
+	if entry.Connected || entry.Failures != 1 {
+		t.Errorf("failed attempt not restored: %+v", entry)
+	}
+}
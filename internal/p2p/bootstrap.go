@@ -0,0 +1,126 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+	ps "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/spf13/viper"
+)
+
+const seedResolveTimeout = 5 * time.Second
+
+//SeedResolver is a source of peers to bootstrap from
+type SeedResolver interface {
+	Resolve(ctx context.Context) ([]ps.PeerInfo, error)
+}
+
+//StaticSeedResolver resolves peers from the list of addresses in format /ip4/<IP>/tcp/<port>/ipfs/<peer ID>
+type StaticSeedResolver []string
+
+func (r StaticSeedResolver) Resolve(ctx context.Context) ([]ps.PeerInfo, error) {
+	return parsePeerAddrs(r)
+}
+
+//DNSSeedResolver resolves peers from TXT records of the seed domain, every record is an address of StaticSeedResolver format
+type DNSSeedResolver struct {
+	Domain   string
+	Resolver *net.Resolver //net.DefaultResolver is used if nil
+}
+
+func (r *DNSSeedResolver) Resolve(ctx context.Context) (peerInfos []ps.PeerInfo, err error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	records, err := resolver.LookupTXT(ctx, r.Domain)
+	if err != nil {
+		err = fmt.Errorf("failed to resolve seed %s: %s", r.Domain, err)
+		return
+	}
+	return parsePeerAddrs(records)
+}
+
+//parsePeerAddrs skips invalid addresses, error is returned only if none of addresses is valid
+func parsePeerAddrs(addrs []string) (peerInfos []ps.PeerInfo, err error) {
+	for _, addrStr := range addrs {
+		peerInfo, parseErr := parsePeerAddr(strings.TrimSpace(addrStr))
+		if parseErr != nil {
+			log.Warningf("Skipping seed address %s: %s\n", addrStr, parseErr)
+			err = parseErr
+			continue
+		}
+		peerInfos = append(peerInfos, peerInfo)
+	}
+	if len(peerInfos) > 0 {
+		err = nil
+	}
+	return
+}
+
+func parsePeerAddr(addrStr string) (peerInfo ps.PeerInfo, err error) {
+	idx := strings.LastIndex(addrStr, "/ipfs/")
+	if idx == -1 {
+		err = fmt.Errorf("no peer ID in address %s", addrStr)
+		return
+	}
+	return newPeerInfo(addrStr[:idx], addrStr[idx+len("/ipfs/"):])
+}
+
+//seedResolvers returns bootstrap peers sources from configuration
+func seedResolvers() (resolvers []SeedResolver) {
+	resolvers = append(resolvers, StaticSeedResolver(viper.GetStringSlice("p2p.bootstrap")))
+	for _, domain := range viper.GetStringSlice("p2p.dnsSeeds") {
+		resolvers = append(resolvers, &DNSSeedResolver{Domain: domain})
+	}
+	return
+}
+
+func resolveSeeds(resolvers []SeedResolver) (peerInfos []ps.PeerInfo) {
+	for _, resolver := range resolvers {
+		ctx, cancel := context.WithTimeout(context.Background(), seedResolveTimeout)
+		seeds, err := resolver.Resolve(ctx)
+		cancel()
+		if err != nil {
+			log.Warningf("Failed to resolve seeds: %s\n", err)
+		}
+		peerInfos = append(peerInfos, seeds...)
+	}
+	return
+}
+
+//mergePeerInfos joins addresses of the same peers, keeping the order peers first appeared in, self is excluded
+func mergePeerInfos(self peer.ID, peerInfos []ps.PeerInfo) (merged []ps.PeerInfo) {
+	positions := make(map[peer.ID]int, len(peerInfos))
+	for _, peerInfo := range peerInfos {
+		if peerInfo.ID == self {
+			continue
+		}
+		pos, ok := positions[peerInfo.ID]
+		if !ok {
+			positions[peerInfo.ID] = len(merged)
+			merged = append(merged, ps.PeerInfo{ID: peerInfo.ID, Addrs: append([]ma.Multiaddr{}, peerInfo.Addrs...)})
+			continue
+		}
+		for _, addr := range peerInfo.Addrs {
+			if !containsAddr(merged[pos].Addrs, addr) {
+				merged[pos].Addrs = append(merged[pos].Addrs, addr)
+			}
+		}
+	}
+	return
+}
+
+func containsAddr(addrs []ma.Multiaddr, addr ma.Multiaddr) bool {
+	for _, a := range addrs {
+		if a.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+func TestDNSSeedResolver_Resolve(t *testing.T) {
+	ids := make([]peer.ID, 2)
+	records := make([]string, 0, len(ids)+1)
+	for i := range ids {
+		_, public, _ := blockchain.NewKeys()
+		ids[i], _ = peer.IDFromPublicKey(public)
+		records = append(records, fmt.Sprintf("/ip4/10.0.0.%d/tcp/9765/ipfs/%s", i, ids[i].Pretty()))
+	}
+	records = append(records, "not an address")
+
+	serverAddr, stop := startTXTServer(t, records)
+	defer stop()
+
+	resolver := &DNSSeedResolver{
+		Domain: "seed.akhcoin.test.",
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "udp", serverAddr)
+			},
+		},
+	}
+
+	peerInfos, err := resolver.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peerInfos) != len(ids) {
+		t.Fatalf("%d peers resolved, expected %d", len(peerInfos), len(ids))
+	}
+	for i, peerInfo := range peerInfos {
+		if peerInfo.ID != ids[i] || len(peerInfo.Addrs) != 1 {
+			t.Errorf("unexpected peer resolved: %s %v", peerInfo.ID.Pretty(), peerInfo.Addrs)
+		}
+	}
+}
+
+func TestMergePeerInfos(t *testing.T) {
+	_, public, _ := blockchain.NewKeys()
+	id, _ := peer.IDFromPublicKey(public)
+	_, selfPublic, _ := blockchain.NewKeys()
+	self, _ := peer.IDFromPublicKey(selfPublic)
+
+	peerInfos, _ := parsePeerAddrs([]string{
+		fmt.Sprintf("/ip4/10.0.0.1/tcp/9765/ipfs/%s", id.Pretty()),
+		fmt.Sprintf("/ip4/10.0.0.2/tcp/9765/ipfs/%s", id.Pretty()),
+		fmt.Sprintf("/ip4/10.0.0.1/tcp/9765/ipfs/%s", id.Pretty()),
+		fmt.Sprintf("/ip4/10.0.0.3/tcp/9765/ipfs/%s", self.Pretty()),
+	})
+
+	merged := mergePeerInfos(self, peerInfos)
+	if len(merged) != 1 || len(merged[0].Addrs) != 2 {
+		t.Fatalf("peers merged incorrectly: %v", merged)
+	}
+}
+
+//startTXTServer starts local DNS stand-in answering every query with given TXT records
+func startTXTServer(t *testing.T, records []string) (addr string, stop func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, remote, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			response := txtResponse(buf[:n], records)
+			if response != nil {
+				conn.WriteTo(response, remote)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func txtResponse(query []byte, records []string) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	//question section: name labels terminated by zero length, then type and class
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+
+	response := make([]byte, 12, 512)
+	copy(response, query[:2])                                      //ID
+	binary.BigEndian.PutUint16(response[2:], 0x8180)               //standard response, recursion available
+	binary.BigEndian.PutUint16(response[4:], 1)                    //questions
+	binary.BigEndian.PutUint16(response[6:], uint16(len(records))) //answers
+	response = append(response, query[12:end]...)
+
+	for _, record := range records {
+		rr := []byte{0xc0, 12, 0, 16, 0, 1, 0, 0, 0, 60} //pointer to question name, TXT, IN, TTL
+		rr = append(rr, 0, byte(len(record)+1), byte(len(record)))
+		rr = append(rr, record...)
+		response = append(response, rr...)
+	}
+	return response
+}
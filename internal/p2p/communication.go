@@ -1,24 +1,11 @@
 package p2p
 
 import (
-	"context"
 	"github.com/alholm/akhcoin/pkg/blockchain"
 	"io"
-	"sync"
 
 	"fmt"
 	"github.com/libp2p/go-libp2p-peer"
-	"github.com/libp2p/go-libp2p-protocol"
-)
-
-const protocolsPrefix = "ip4/akhcoin.org/tcp/"
-
-const (
-	BlockProto         protocol.ID = protocolsPrefix + "block/1.0.0"
-	TransactionProto               = protocolsPrefix + "transaction/1.0.0"
-	BlockAnnounceProto             = protocolsPrefix + "blockAnnounce/1.0.0"
-	DiscoverProto                  = protocolsPrefix + "discover/1.0.0"
-	VoteAnnounceProto              = protocolsPrefix + "vote/1.0.0"
 )
 
 type GetBlockMessage struct {
@@ -30,7 +17,7 @@ type BlockStreamHandler struct {
 	Head **blockchain.Block
 }
 
-func (brp *BlockStreamHandler) protocol() protocol.ID {
+func (brp *BlockStreamHandler) protocol() string {
 	return BlockProto
 }
 
@@ -39,83 +26,155 @@ func (brp *BlockStreamHandler) handle(ws *WrappedStream) {
 	err := receiveMessage(&msg, ws)
 	if err != nil {
 		log.Warningf("Failed to decode stream: %s\n", err)
+		ws.penalize(MalformedMessage)
+		sendResponse(ws, StatusRejected, MalformedMessage.String())
 		return
 	}
 
 	nextBlock := *brp.Head
-	for nextBlock != nil {
-
-		if nextBlock.Hash == msg.BlockHash {
-			log.Debugf("%s: sending block %s\n", ws.stream.Conn().LocalPeer().Pretty(), nextBlock.Hash)
-			err = sendMessage(nextBlock.BlockData, ws)
-			if err != nil {
-				log.Warningf("%s: Failed to transmit a block: %s\n", ws.stream.Conn().RemotePeer().Pretty(), err)
-				break
-			}
-		}
-
+	for nextBlock != nil && nextBlock.Hash != msg.BlockHash {
 		nextBlock = nextBlock.Parent
 	}
+	if nextBlock == nil {
+		log.Debugf("%s: block %s requested by %s not found\n", ws.stream.Conn().LocalPeer().Pretty(), msg.BlockHash, ws.RemotePeer().Pretty())
+		sendResponse(ws, StatusNotFound, msg.BlockHash)
+		return
+	}
 
+	log.Debugf("%s: sending block %s\n", ws.stream.Conn().LocalPeer().Pretty(), nextBlock.Hash)
+	err = sendResponse(ws, StatusOK, "")
+	if err == nil {
+		err = sendMessage(nextBlock.BlockData, ws)
+	}
+	if err != nil {
+		log.Warningf("%s: Failed to transmit a block: %s\n", ws.stream.Conn().RemotePeer().Pretty(), err)
+	}
 }
 
 type TransactionStreamHandler struct {
-	ProcessResult func(t blockchain.Transaction)
+	ProcessResult func(t blockchain.Transaction, peerId peer.ID) error
 }
 
-func (trp *TransactionStreamHandler) protocol() protocol.ID {
+func (trp *TransactionStreamHandler) protocol() string {
 	return TransactionProto
 }
 
 func (trp *TransactionStreamHandler) handle(ws *WrappedStream) {
-	var t blockchain.Transaction
-	err := receiveMessage(&t, ws)
-	if err != nil {
-		log.Warningf("Failed to process transaction msg: %s\n", err)
-		return
-	}
+	//sender may reuse the stream for several messages
+	for {
+		var t blockchain.Transaction
+		err := receiveMessage(&t, ws)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Warningf("Failed to process transaction msg: %s\n", err)
+			ws.penalize(MalformedMessage)
+			sendResponse(ws, StatusRejected, MalformedMessage.String())
+			return
+		}
 
-	trp.ProcessResult(t)
+		err = ws.handleMessage(func() error { return trp.ProcessResult(t, ws.stream.Conn().RemotePeer()) })
+		if err != nil {
+			log.Warningf("%s: Failed to respond to transaction msg: %s\n", ws.RemotePeer().Pretty(), err)
+			return
+		}
+	}
 }
 
 type AnnouncedBlockStreamHandler struct {
-	ProcessResult func(bd blockchain.BlockData, peerId peer.ID)
+	ProcessResult func(bd blockchain.BlockData, peerId peer.ID) error
 }
 
 func (abrp *AnnouncedBlockStreamHandler) handle(ws *WrappedStream) {
-	var bd blockchain.BlockData
-	err := receiveMessage(&bd, ws)
-	if err != nil {
-		log.Warningf("Failed to process block msg: %s\n", err)
-		return
-	}
+	//sender may reuse the stream for several messages
+	for {
+		var bd blockchain.BlockData
+		err := receiveMessage(&bd, ws)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Warningf("Failed to process block msg: %s\n", err)
+			ws.penalize(MalformedMessage)
+			sendResponse(ws, StatusRejected, MalformedMessage.String())
+			return
+		}
 
-	abrp.ProcessResult(bd, ws.stream.Conn().RemotePeer())
+		err = ws.handleMessage(func() error { return abrp.ProcessResult(bd, ws.stream.Conn().RemotePeer()) })
+		if err != nil {
+			log.Warningf("%s: Failed to respond to block msg: %s\n", ws.RemotePeer().Pretty(), err)
+			return
+		}
+	}
 }
 
-func (*AnnouncedBlockStreamHandler) protocol() protocol.ID {
+func (*AnnouncedBlockStreamHandler) protocol() string {
 	return BlockAnnounceProto
 }
 
 type VoteStreamHandler struct {
-	ProcessResult func(v blockchain.Vote)
+	ProcessResult func(v blockchain.Vote, peerId peer.ID) error
 }
 
 func (vrp *VoteStreamHandler) handle(ws *WrappedStream) {
-	var v blockchain.Vote
-	err := receiveMessage(&v, ws)
-	if err != nil {
-		log.Warningf("Failed to process Vote msg: %s\n", err)
-		return
-	}
+	//sender may reuse the stream for several messages
+	for {
+		var v blockchain.Vote
+		err := receiveMessage(&v, ws)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Warningf("Failed to process Vote msg: %s\n", err)
+			ws.penalize(MalformedMessage)
+			sendResponse(ws, StatusRejected, MalformedMessage.String())
+			return
+		}
 
-	vrp.ProcessResult(v)
+		err = ws.handleMessage(func() error { return vrp.ProcessResult(v, ws.stream.Conn().RemotePeer()) })
+		if err != nil {
+			log.Warningf("%s: Failed to respond to Vote msg: %s\n", ws.RemotePeer().Pretty(), err)
+			return
+		}
+	}
 }
 
-func (*VoteStreamHandler) protocol() protocol.ID {
+func (*VoteStreamHandler) protocol() string {
 	return VoteAnnounceProto
 }
 
+type EvidenceStreamHandler struct {
+	ProcessResult func(e blockchain.Equivocation, peerId peer.ID) error
+}
+
+func (erp *EvidenceStreamHandler) handle(ws *WrappedStream) {
+	//sender may reuse the stream for several messages
+	for {
+		var e blockchain.Equivocation
+		err := receiveMessage(&e, ws)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Warningf("Failed to process evidence msg: %s\n", err)
+			ws.penalize(MalformedMessage)
+			sendResponse(ws, StatusRejected, MalformedMessage.String())
+			return
+		}
+
+		err = ws.handleMessage(func() error { return erp.ProcessResult(e, ws.stream.Conn().RemotePeer()) })
+		if err != nil {
+			log.Warningf("%s: Failed to respond to evidence msg: %s\n", ws.RemotePeer().Pretty(), err)
+			return
+		}
+	}
+}
+
+func (*EvidenceStreamHandler) protocol() string {
+	return EvidenceProto
+}
+
 func (h *AkhHost) GetBlock(peerID peer.ID, blockHash string) (bd blockchain.BlockData, err error) {
 	msg := &GetBlockMessage{BlockHash: blockHash}
 	ws, err := h.SendMessage(msg, peerID, BlockProto)
@@ -123,51 +182,56 @@ func (h *AkhHost) GetBlock(peerID peer.ID, blockHash string) (bd blockchain.Bloc
 		return
 	}
 
-	err = receiveMessage(&bd, ws)
+	defer ws.stream.Close()
+
+	err = receiveResponse(ws)
+	if err == nil {
+		err = receiveMessage(&bd, ws)
+	}
 	if err != nil {
-		if err != io.EOF {
+		if !IsNotFound(err) {
 			log.Warningf("%s: %s stream to %s processing ended: %s", h.ID(), BlockProto, peerID.Pretty(), err)
-			err = fmt.Errorf("failed to receive block %s : %s", blockHash, err)
-		} else {
-			err = fmt.Errorf("block %s wasn't received", blockHash)
 		}
+		err = fmt.Errorf("failed to receive block %s: %s", blockHash, err)
 		return
-
 	}
 	log.Debugf("%s: BlockData received from %s: %s", h.ID(), ws.stream.Conn().RemotePeer().Pretty(), bd.Hash)
 
 	return
 }
 
-func (h *AkhHost) PublishTransaction(t *blockchain.Transaction) {
-	h.publish(t, TransactionProto)
+func (h *AkhHost) PublishTransaction(t *blockchain.Transaction) error {
+	return h.publish(t, TransactionProto)
 }
-func (h *AkhHost) PublishBlock(b *blockchain.Block) {
-	h.publish(&b.BlockData, BlockAnnounceProto)
+func (h *AkhHost) PublishBlock(b *blockchain.Block) error {
+	return h.publish(&b.BlockData, BlockAnnounceProto)
 }
-func (h *AkhHost) PublishVote(v *blockchain.Vote) {
-	h.publish(v, VoteAnnounceProto)
+func (h *AkhHost) PublishVote(v *blockchain.Vote) error {
+	return h.publish(v, VoteAnnounceProto)
+}
+func (h *AkhHost) PublishEvidence(e *blockchain.Equivocation) error {
+	return h.publish(e, EvidenceProto)
 }
 
-//TODO error handling, conditional peers selection
-func (h *AkhHost) publish(t interface{}, proto protocol.ID) {
-	var wg sync.WaitGroup
-	for _, peerID := range h.Peerstore().Peers() {
-		wg.Add(1)
-		go func(peerID peer.ID) {
-			defer wg.Done()
-			log.Debugf("%T published to %s - %s \n", t, peerID.Pretty(), h.Peerstore().Addrs(peerID))
-			stream, err := h.NewStream(context.Background(), peerID, proto)
-			//defer stream.Close()
-			if err != nil {
-				log.Warningf("Error publishing %T to %s: %s\n", t, peerID.Pretty(), err)
-				return
-			}
-			ws := WrapStream(stream)
-
-			sendMessage(t, ws)
-		}(peerID)
+//publish puts message to outbound queues of connected peers, error is returned if some of them are full.
+//Delivery errors are logged and collected in queues stats, queues are removed on disconnect.
+//TODO conditional peers selection
+func (h *AkhHost) publish(t interface{}, proto string) (err error) {
+	peersN, failedN := 0, 0
+	for _, peerID := range h.ConnManager.Peers() {
+		if peerID == h.ID() || h.Scores.IsBanned(peerID) {
+			continue
+		}
+		peersN++
+		queueErr := h.Outbound.Enqueue(peerID, proto, t)
+		if queueErr != nil {
+			log.Warningf("Error publishing %T to %s: %s\n", t, peerID.Pretty(), queueErr)
+			failedN++
+		}
+	}
+	if failedN > 0 {
+		err = fmt.Errorf("%T %s not published to %d of %d peers", t, t, failedN, peersN)
 	}
-	wg.Wait()
-	log.Debugf("%T %s sent\n", t, t)
+	log.Debugf("%T %s queued to %d peers\n", t, t, peersN-failedN)
+	return
 }
@@ -0,0 +1,223 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	"github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+//ConnManager keeps number of connected peers between low and high watermarks and limits number of peers from the same
+//subnet (bucket), so that single operator can not surround node with its own peers
+type ConnManager struct {
+	low, high   int
+	bucketSize  int
+	gracePeriod time.Duration
+	conns       map[peer.ID]connInfo
+	buckets     map[string]int
+	scores      *PeerScores
+	sync.Mutex
+}
+
+type connInfo struct {
+	opened time.Time
+	bucket string
+}
+
+func NewConnManager(low, high, bucketSize int, gracePeriod time.Duration, scores *PeerScores) *ConnManager {
+	return &ConnManager{
+		low:         low,
+		high:        high,
+		bucketSize:  bucketSize,
+		gracePeriod: gracePeriod,
+		conns:       make(map[peer.ID]connInfo),
+		buckets:     make(map[string]int),
+		scores:      scores,
+	}
+}
+
+//AddrBucket returns subnet of the address: /16 for IPv4 and /32 for IPv6.
+//Loopback and non-IP addresses have empty bucket, which is not limited.
+func AddrBucket(addr ma.Multiaddr) string {
+	ipStr, err := addr.ValueForProtocol(ma.P_IP4)
+	bits := 16
+	if err != nil {
+		ipStr, err = addr.ValueForProtocol(ma.P_IP6)
+		bits = 32
+	}
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%s/%d", ip4.Mask(net.CIDRMask(bits, 32)), bits)
+	}
+	return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(bits, 128)), bits)
+}
+
+//peerBucket returns bucket of the first routable peer address
+func peerBucket(addrs []ma.Multiaddr) string {
+	for _, addr := range addrs {
+		if bucket := AddrBucket(addr); bucket != "" {
+			return bucket
+		}
+	}
+	return ""
+}
+
+//Connected registers new connection, returns false if connection has to be dropped
+func (cm *ConnManager) Connected(id peer.ID, addr ma.Multiaddr) bool {
+	cm.Lock()
+	defer cm.Unlock()
+	if _, ok := cm.conns[id]; ok {
+		return true
+	}
+	bucket := AddrBucket(addr)
+	if bucket != "" && cm.buckets[bucket] >= cm.bucketSize {
+		return false
+	}
+	cm.conns[id] = connInfo{time.Now(), bucket}
+	cm.buckets[bucket]++
+	return true
+}
+
+func (cm *ConnManager) Disconnected(id peer.ID) {
+	cm.Lock()
+	defer cm.Unlock()
+	info, ok := cm.conns[id]
+	if !ok {
+		return
+	}
+	delete(cm.conns, id)
+	cm.buckets[info.bucket]--
+}
+
+//Bucket returns subnet bucket of the connected peer
+func (cm *ConnManager) Bucket(id peer.ID) (bucket string, ok bool) {
+	cm.Lock()
+	defer cm.Unlock()
+	info, ok := cm.conns[id]
+	return info.bucket, ok
+}
+
+//Peers returns connected peers
+func (cm *ConnManager) Peers() (ids []peer.ID) {
+	cm.Lock()
+	defer cm.Unlock()
+	for id := range cm.conns {
+		ids = append(ids, id)
+	}
+	return
+}
+
+//HasCapacity tells whether new outgoing connections are welcome
+func (cm *ConnManager) HasCapacity() bool {
+	cm.Lock()
+	defer cm.Unlock()
+	return len(cm.conns) < cm.high
+}
+
+func (cm *ConnManager) Count() int {
+	cm.Lock()
+	defer cm.Unlock()
+	return len(cm.conns)
+}
+
+//BucketFull tells whether there are enough peers from the address subnet already
+func (cm *ConnManager) BucketFull(addr ma.Multiaddr) bool {
+	bucket := AddrBucket(addr)
+	cm.Lock()
+	defer cm.Unlock()
+	return bucket != "" && cm.buckets[bucket] >= cm.bucketSize
+}
+
+//toPrune returns peers to disconnect when high watermark is exceeded to get back to low watermark.
+//Peers with lower score go first, younger ones go first among equally scored. Peers within grace period are kept.
+func (cm *ConnManager) toPrune() (ids []peer.ID) {
+	cm.Lock()
+	defer cm.Unlock()
+	if len(cm.conns) <= cm.high {
+		return
+	}
+
+	type candidate struct {
+		id     peer.ID
+		score  int
+		opened time.Time
+	}
+	candidates := make([]candidate, 0, len(cm.conns))
+	now := time.Now()
+	for id, info := range cm.conns {
+		if now.Sub(info.opened) < cm.gracePeriod {
+			continue
+		}
+		candidates = append(candidates, candidate{id, cm.scores.Score(id), info.opened})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].opened.After(candidates[j].opened)
+	})
+
+	n := len(cm.conns) - cm.low
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	for _, c := range candidates[:n] {
+		ids = append(ids, c.id)
+	}
+	return
+}
+
+//connNotifee feeds host connections to ConnManager
+type connNotifee struct {
+	h *AkhHost
+}
+
+func (n *connNotifee) Connected(network inet.Network, conn inet.Conn) {
+	id := conn.RemotePeer()
+	if n.h.Scores.IsBanned(id) || !n.h.ConnManager.Connected(id, conn.RemoteMultiaddr()) {
+		log.Debugf("%s: dropping connection to %s", n.h.ID().Pretty(), id.Pretty())
+		go conn.Close()
+		return
+	}
+	go n.h.trimConnections()
+	go func() {
+		err := n.h.sampleTime(id)
+		if err != nil {
+			log.Debugf("%s: failed to sample time of %s: %s", n.h.ID().Pretty(), id.Pretty(), err)
+		}
+	}()
+}
+
+func (n *connNotifee) Disconnected(network inet.Network, conn inet.Conn) {
+	if len(network.ConnsToPeer(conn.RemotePeer())) == 0 {
+		n.h.ConnManager.Disconnected(conn.RemotePeer())
+		n.h.Outbound.Remove(conn.RemotePeer())
+		n.h.TimeOffsets.Remove(conn.RemotePeer())
+	}
+}
+
+func (n *connNotifee) Listen(inet.Network, ma.Multiaddr)      {}
+func (n *connNotifee) ListenClose(inet.Network, ma.Multiaddr) {}
+func (n *connNotifee) OpenedStream(inet.Network, inet.Stream) {}
+func (n *connNotifee) ClosedStream(inet.Network, inet.Stream) {}
+
+func (h *AkhHost) trimConnections() {
+	for _, id := range h.ConnManager.toPrune() {
+		log.Debugf("%s: pruning connection to %s", h.ID().Pretty(), id.Pretty())
+		err := h.Network().ClosePeer(id)
+		if err != nil {
+			log.Warningf("Failed to disconnect %s: %s\n", id.Pretty(), err)
+		}
+	}
+}
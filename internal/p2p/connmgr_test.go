@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrBucket(t *testing.T) {
+	buckets := map[string]string{
+		"/ip4/10.1.2.3/tcp/9765":      "10.1.0.0/16",
+		"/ip4/10.1.200.1/tcp/9765":    "10.1.0.0/16",
+		"/ip4/127.0.0.1/tcp/9765":     "",
+		"/ip6/2001:db8:1::1/tcp/9765": "2001:db8::/32",
+		"/ip6/::1/tcp/9765":           "",
+	}
+	for addrStr, expected := range buckets {
+		addr, _ := ma.NewMultiaddr(addrStr)
+		if bucket := AddrBucket(addr); bucket != expected {
+			t.Errorf("%s bucket = %s, expected %s", addrStr, bucket, expected)
+		}
+	}
+}
+
+func TestConnManager_Connected(t *testing.T) {
+	cm := NewConnManager(1, 2, 2, 0, nil)
+	addr, _ := ma.NewMultiaddr("/ip4/10.1.2.3/tcp/9765")
+	local, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/9765")
+
+	if !cm.Connected(peer.ID("a"), addr) || !cm.Connected(peer.ID("b"), addr) {
+		t.Fatal("connection within bucket size dropped")
+	}
+	if cm.Connected(peer.ID("c"), addr) {
+		t.Fatal("connection exceeding bucket size accepted")
+	}
+	if !cm.Connected(peer.ID("c"), local) {
+		t.Fatal("loopback connection dropped")
+	}
+	if cm.HasCapacity() {
+		t.Fatal("capacity reported above high watermark")
+	}
+
+	cm.Disconnected(peer.ID("a"))
+	if cm.BucketFull(addr) {
+		t.Fatal("bucket not released on disconnect")
+	}
+}
+
+func TestConnManager_toPrune(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "akhconnmgr")
+	defer os.RemoveAll(dir)
+	scores := NewPeerScores(dir, -100, time.Hour, 0)
+	cm := NewConnManager(2, 3, 10, 0, scores)
+
+	ids := []peer.ID{"oldest", "misbehaving", "old", "young"}
+	for _, id := range ids {
+		addr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/9765")
+		cm.Connected(id, addr)
+		time.Sleep(time.Millisecond)
+	}
+	scores.Penalize(peer.ID("misbehaving"), Spam)
+
+	pruned := cm.toPrune()
+	if len(pruned) != 2 || pruned[0] != "misbehaving" || pruned[1] != "young" {
+		t.Fatalf("unexpected peers pruned: %v", pruned)
+	}
+
+	cm.gracePeriod = time.Hour
+	if len(cm.toPrune()) != 0 {
+		t.Fatal("peers within grace period pruned")
+	}
+}
@@ -1,47 +1,33 @@
 package p2p
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"os"
+	"math/rand"
 	"strings"
 	"time"
 
+	inet "github.com/libp2p/go-libp2p-net"
 	"github.com/libp2p/go-libp2p-peer"
 	ps "github.com/libp2p/go-libp2p-peerstore"
-	"github.com/libp2p/go-libp2p-protocol"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-const HostsInfoPath = "/tmp/akhhosts.info"
 const DefaultPort = 9765
 
-func (h *AkhHost) DiscoverPeers() {
-	peers, err := readHostsInfo()
-	log.Debugf("Pre-defined peers number = %d (%v)\n", len(peers), err)
-	h.populatePeerStore(peers)
-}
-
-func readHostsInfo() (peers []ps.PeerInfo, err error) {
-	file, err := os.Open(HostsInfoPath)
-	if err != nil {
-		return
-	}
-	defer file.Close()
-	//fmt.Sprintf("%s:%s\n", h.Addrs()[0], h.ID().Pretty())
+const (
+	maxPeersPerRequest = 32
+	maxAddrsPerPeer    = 8
+	maxPeerRecordAge   = 3 * 24 * time.Hour
+)
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		split := strings.Split(line, ":")
-		peerInfo, err := newPeerInfo(split[0], split[1])
-		if err == nil {
-			peers = append(peers, peerInfo)
-		}
-	}
-	err = scanner.Err()
-	return
+//DiscoverPeers connects to peers remembered in address book, configured bootstrap peers and DNS seeds
+func (h *AkhHost) DiscoverPeers() {
+	peers := h.AddrBook.PeerInfos()
+	log.Debugf("Address book peers number = %d\n", len(peers))
+	seeds := resolveSeeds(seedResolvers())
+	log.Debugf("Seed peers number = %d\n", len(seeds))
+	h.populatePeerStore(mergePeerInfos(h.ID(), append(peers, seeds...)))
 }
 
 type TestedPeer struct {
@@ -72,7 +58,9 @@ func (h *AkhHost) populatePeerStore(peerInfos []ps.PeerInfo) {
 			}
 		case testedPeer := <-peerCh:
 			processed++
-			if testedPeer.err == nil {
+			if h.Scores.IsBanned(testedPeer.ID) {
+				log.Debugf("populatePeerStore: Skipping banned peer: %s\n", testedPeer.ID.Pretty())
+			} else if testedPeer.err == nil {
 				log.Debugf("populatePeerStore: Received peer: %s\n", testedPeer.ID.Pretty())
 				h.savePeer(testedPeer.PeerInfo)
 			} else {
@@ -101,10 +89,14 @@ func getPeers(h *AkhHost, peerInfos []ps.PeerInfo, depth int, ch chan TestedPeer
 			//as this function recursive call already sent len(peerInfos) to counterCh
 			defer func() { countCh <- -1 }()
 
+			if !h.ConnManager.HasCapacity() {
+				ch <- TestedPeer{peerInfo, fmt.Errorf("connections limit reached")}
+				return
+			}
 			testedPeer := TestedPeer{peerInfo, h.testPeer(peerInfo)}
 			ch <- testedPeer
 
-			if depth != 0 {
+			if depth != 0 && testedPeer.err == nil {
 				peerPeers, err := h.askForPeers(peerInfo.ID)
 				if err != nil {
 					log.Debugf("asking for peers failed: %s\n", err)
@@ -119,30 +111,105 @@ func getPeers(h *AkhHost, peerInfos []ps.PeerInfo, depth int, ch chan TestedPeer
 }
 
 func (h *AkhHost) savePeer(peerInfo ps.PeerInfo) {
+	if len(h.Peerstore().Addrs(peerInfo.ID)) == 0 && h.bucketFull(peerBucket(peerInfo.Addrs)) {
+		log.Debugf("%s: not saving %s, too many peers from the same subnet", h.ID().Pretty(), peerInfo.ID.Pretty())
+		return
+	}
 	h.Peerstore().SetAddrs(peerInfo.ID, peerInfo.Addrs, ps.PermanentAddrTTL)
+	h.AddrBook.Add(peerInfo)
 }
-func (h *AkhHost) testPeer(peerInfo ps.PeerInfo) error {
-	return h.Connect(context.Background(), peerInfo)
+
+//bucketFull tells whether peerstore already has enough peers from the bucket
+func (h *AkhHost) bucketFull(bucket string) bool {
+	if bucket == "" {
+		return false
+	}
+	n := 0
+	for _, id := range h.Peerstore().Peers() {
+		if peerBucket(h.Peerstore().Addrs(id)) == bucket {
+			n++
+		}
+	}
+	return n >= h.ConnManager.bucketSize
+}
+func (h *AkhHost) testPeer(peerInfo ps.PeerInfo) (err error) {
+	err = h.Connect(context.Background(), peerInfo)
+	if peerInfo.ID != h.ID() {
+		h.AddrBook.MarkAttempt(peerInfo.ID, err)
+	}
+	return
 }
 
 func (h *AkhHost) askForPeers(peerID peer.ID) (peerInfos []ps.PeerInfo, err error) {
 	log.Debugf("%s asking for peers from %s\n", h.ID().Pretty(), peerID.Pretty())
-	var idAddrMap map[string]string
+	var records []PeerRecord
+
+	err = h.ask(peerID, GetPeersMessage{Max: maxPeersPerRequest}, DiscoverProto, &records)
+	if err != nil {
+		return
+	}
+	if len(records) > maxPeersPerRequest {
+		h.Penalize(peerID, Spam)
+		records = records[:maxPeersPerRequest]
+	}
 
-	err = h.ask(peerID, GetPeersMessage{}, DiscoverProto, &idAddrMap)
+	peerInfos, protocols := validatePeerRecords(records, maxPeerRecordAge, func(id peer.ID) bool {
+		return id == h.ID() || id == peerID || h.Scores.IsBanned(id)
+	})
+	for id, protos := range protocols {
+		h.Peerstore().AddProtocols(id, protos...)
+	}
+	return
+}
 
-	for id, addr := range idAddrMap {
-		peerInfo, peerErr := newPeerInfo(addr, id)
-		if peerErr != nil {
-			log.Warningf("Error adding peer %s, %s: %s\n", id, addr, peerErr)
+//validatePeerRecords converts received records to peer infos. Records with invalid ID, without valid addresses,
+//not seen for longer than maxAge and excluded ones are dropped, addresses of duplicated records are merged.
+func validatePeerRecords(records []PeerRecord, maxAge time.Duration, exclude func(peer.ID) bool) (peerInfos []ps.PeerInfo, protocols map[peer.ID][]string) {
+	protocols = make(map[peer.ID][]string)
+	oldest := time.Now().Add(-maxAge).UnixNano()
+	for _, record := range records {
+		id, err := peer.IDB58Decode(record.ID)
+		if err != nil {
+			log.Debugf("Skipping peer record with invalid ID %s: %s\n", record.ID, err)
+			continue
+		}
+		if exclude(id) || record.LastSeen < oldest {
+			continue
+		}
+		peerInfo := ps.PeerInfo{ID: id}
+		for i, addrStr := range record.Addrs {
+			if i == maxAddrsPerPeer {
+				break
+			}
+			addr, err := ma.NewMultiaddr(addrStr)
+			if err != nil {
+				log.Debugf("Skipping invalid address %s of %s: %s\n", addrStr, record.ID, err)
+				continue
+			}
+			peerInfo.Addrs = append(peerInfo.Addrs, addr)
+		}
+		if len(peerInfo.Addrs) == 0 {
+			continue
 		}
 		peerInfos = append(peerInfos, peerInfo)
+		protocols[id] = append(protocols[id], record.Protocols...)
 	}
+	peerInfos = mergePeerInfos("", peerInfos)
 	return
 }
 
+//GetPeersMessage asks for random sample of at most Max known peers
 type GetPeersMessage struct {
 	Message
+	Max int
+}
+
+//PeerRecord is a peer description exchanged by discover protocol
+type PeerRecord struct {
+	ID        string
+	Addrs     []string
+	LastSeen  int64 //unix nano
+	Protocols []string
 }
 
 func newPeerInfo(addrStr string, remotePeerID string) (peerInfo ps.PeerInfo, err error) {
@@ -159,37 +226,74 @@ func newPeerInfo(addrStr string, remotePeerID string) (peerInfo ps.PeerInfo, err
 }
 
 type DiscoverStreamHandler struct {
-	store *ps.Peerstore
+	host *AkhHost
 }
 
-func (*DiscoverStreamHandler) protocol() protocol.ID {
+func (*DiscoverStreamHandler) protocol() string {
 	return DiscoverProto
 }
 
 func (drp *DiscoverStreamHandler) handle(ws *WrappedStream) {
-
+	msg := &GetPeersMessage{}
 	getAnswer := func() interface{} {
-		peerIDs := (*drp.store).Peers()
-		infos := make(map[string]string, len(peerIDs))
-		for _, id := range peerIDs {
-			if id == ws.stream.Conn().RemotePeer() {
-				continue
-			}
-			addrs := (*drp.store).Addrs(id)
-			//localhost has no self addrs
-			if len(addrs) > 0 {
-				infos[id.Pretty()] = addrs[0].String()
-			}
-		}
-		return infos
+		return drp.host.peerRecords(ws.stream.Conn().RemotePeer(), msg.Max)
 	}
 
-	err := answer(ws, &GetPeersMessage{}, getAnswer)
+	err := answer(ws, msg, getAnswer)
 	if err != nil {
 		log.Warningf("Error handling discover stream: %s", err)
 	}
 }
 
+//peerRecords returns random sample of at most max known peers
+func (h *AkhHost) peerRecords(requester peer.ID, max int) (records []PeerRecord) {
+	if max <= 0 || max > maxPeersPerRequest {
+		max = maxPeersPerRequest
+	}
+	var candidates []peer.ID
+	for _, id := range h.Peerstore().Peers() {
+		if id == h.ID() || id == requester || h.Scores.IsBanned(id) {
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+
+	records = make([]PeerRecord, 0, max)
+	for _, i := range rand.Perm(len(candidates)) {
+		if len(records) == max {
+			break
+		}
+		record, ok := h.peerRecord(candidates[i])
+		if ok {
+			records = append(records, record)
+		}
+	}
+	return
+}
+
+//peerRecord describes peer, ok is false if there is nothing to tell about it
+func (h *AkhHost) peerRecord(id peer.ID) (record PeerRecord, ok bool) {
+	addrs := h.Peerstore().Addrs(id)
+	if len(addrs) == 0 {
+		return
+	}
+	record.ID = id.Pretty()
+	for _, addr := range addrs {
+		record.Addrs = append(record.Addrs, addr.String())
+	}
+
+	if h.Network().Connectedness(id) == inet.Connected {
+		record.LastSeen = time.Now().UnixNano()
+	} else if entry, known := h.AddrBook.Get(id); known && !entry.LastSeen.IsZero() {
+		record.LastSeen = entry.LastSeen.UnixNano()
+	} else {
+		return
+	}
+
+	record.Protocols, _ = h.Peerstore().GetProtocols(id)
+	return record, true
+}
+
 //remotePeerAddr format: <dot-separated IPv4>:<post>, for example: 127.0.0.1:9000
 //remotePeerID  - unprettyfied ID
 //TODO validation and error handling
@@ -216,6 +320,9 @@ type DiscoveryNotifee struct {
 }
 
 func (n *DiscoveryNotifee) HandlePeerFound(peerInfo ps.PeerInfo) {
+	if n.h.Scores.IsBanned(peerInfo.ID) {
+		return
+	}
 	//log.Debugf("Peer discovered: %s", peerInfo.ID.Pretty())
 	err := n.h.testPeer(peerInfo)
 	if err != nil {
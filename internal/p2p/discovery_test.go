@@ -4,9 +4,10 @@ import (
 	"github.com/alholm/akhcoin/pkg/blockchain"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-crypto"
+	"github.com/libp2p/go-libp2p-peer"
 	"github.com/libp2p/go-libp2p-peerstore"
-	"os"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -16,8 +17,6 @@ func init() {
 
 func TestAkhHost_DiscoverPeers(t *testing.T) {
 
-	os.Remove(HostsInfoPath)
-
 	var h [3]AkhHost
 
 	for i := 0; i < 3; i++ {
@@ -54,3 +53,32 @@ func startRandomHost(p int) AkhHost {
 	privateBytes, _ := crypto.MarshalPrivateKey(private)
 	return StartHost(p, privateBytes, false)
 }
+
+func TestValidatePeerRecords(t *testing.T) {
+	ids := make([]peer.ID, 4)
+	for i := range ids {
+		_, public, _ := blockchain.NewKeys()
+		ids[i], _ = peer.IDFromPublicKey(public)
+	}
+	now := time.Now().UnixNano()
+	records := []PeerRecord{
+		{ID: ids[0].Pretty(), Addrs: []string{"/ip4/10.0.0.1/tcp/9765"}, LastSeen: now, Protocols: []string{string(BlockProto)}},
+		{ID: ids[0].Pretty(), Addrs: []string{"/ip4/10.0.0.2/tcp/9765", "invalid"}, LastSeen: now},
+		{ID: ids[1].Pretty(), Addrs: []string{"/ip4/10.0.0.3/tcp/9765"}, LastSeen: now - int64(2*time.Hour)},
+		{ID: ids[2].Pretty(), Addrs: []string{"invalid"}, LastSeen: now},
+		{ID: ids[3].Pretty(), Addrs: []string{"/ip4/10.0.0.4/tcp/9765"}, LastSeen: now},
+		{ID: "invalid", Addrs: []string{"/ip4/10.0.0.5/tcp/9765"}, LastSeen: now},
+	}
+
+	peerInfos, protocols := validatePeerRecords(records, time.Hour, func(id peer.ID) bool { return id == ids[3] })
+
+	if len(peerInfos) != 1 || peerInfos[0].ID != ids[0] {
+		t.Fatalf("records validated incorrectly: %v", peerInfos)
+	}
+	if len(peerInfos[0].Addrs) != 2 {
+		t.Errorf("addresses of duplicated records not merged: %v", peerInfos[0].Addrs)
+	}
+	if len(protocols[ids[0]]) != 1 {
+		t.Errorf("protocols not collected: %v", protocols)
+	}
+}
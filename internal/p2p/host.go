@@ -4,26 +4,60 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-crypto"
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
 	inet "github.com/libp2p/go-libp2p-net"
 	"github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
-	"github.com/libp2p/go-libp2p-protocol"
 	"github.com/libp2p/go-libp2p-swarm"
 	"github.com/libp2p/go-libp2p/p2p/discovery"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multicodec"
 	json "github.com/multiformats/go-multicodec/json"
+	"github.com/spf13/viper"
 )
 
 var log = logging.Logger("p2p")
 
+func init() {
+	viper.SetDefault("dataDir", ".akhcoin")
+	viper.SetDefault("p2p.network", "main")
+	viper.SetDefault("p2p.banThreshold", -100)
+	viper.SetDefault("p2p.banPeriod", 24*60*60)
+	viper.SetDefault("p2p.scoreRecovery", 60)
+	viper.SetDefault("p2p.addrBookSavePeriod", 60)
+	viper.SetDefault("p2p.lowWater", 16)
+	viper.SetDefault("p2p.highWater", 32)
+	viper.SetDefault("p2p.bucketSize", 4)
+	viper.SetDefault("p2p.gracePeriod", 20)
+	viper.SetDefault("p2p.outboundQueueSize", 64)
+	viper.SetDefault("p2p.responseTimeout", 10)
+	viper.SetDefault("p2p.rateLimit.rate", 10)
+	viper.SetDefault("p2p.rateLimit.burst", 20)
+	viper.SetDefault("p2p.rateLimit.maxHandlers", 64)
+	viper.SetDefault("p2p.rateLimit.maxViolations", 10)
+	viper.SetDefault("p2p.rateLimit.maxStreams", 2)
+}
+
 type AkhHost struct {
 	bhost.BasicHost
+	Scores             *PeerScores
+	AddrBook           *AddrBook
+	ConnManager        *ConnManager
+	NetworkName        string //part of protocol IDs, nodes of different networks don't talk to each other
+	NetworkFingerprint string //hex fingerprint of private network swarm key, empty for public network
+	Outbound           *OutboundQueues
+	RateLimiter        *RateLimiter
+	TimeOffsets        *TimeOffsets
+	middleware         *middlewareChain
+	metrics            *handlerMetrics
+	stop               chan struct{}
 }
 
 type Message interface {
@@ -32,26 +66,30 @@ type Message interface {
 }
 
 type WrappedStream struct {
-	stream inet.Stream
-	enc    multicodec.Encoder
-	dec    multicodec.Decoder
-	w      *bufio.Writer
-	r      *bufio.Reader
+	stream  inet.Stream
+	enc     multicodec.Encoder
+	dec     multicodec.Decoder
+	w       *bufio.Writer
+	r       *bufio.Reader
+	host    *AkhHost
+	counter *countingStream
 }
 
 func WrapStream(s inet.Stream) *WrappedStream {
-	reader := bufio.NewReader(s)
-	writer := bufio.NewWriter(s)
+	counter := &countingStream{Stream: s}
+	reader := bufio.NewReader(counter)
+	writer := bufio.NewWriter(counter)
 	// TODO use binary or protobuf
 	// See https://godoc.org/github.com/multiformats/go-multicodec/json
 	dec := json.Multicodec(false).Decoder(reader)
 	enc := json.Multicodec(false).Encoder(writer)
 	return &WrappedStream{
-		stream: s,
-		r:      reader,
-		w:      writer,
-		enc:    enc,
-		dec:    dec,
+		stream:  s,
+		r:       reader,
+		w:       writer,
+		enc:     enc,
+		dec:     dec,
+		counter: counter,
 	}
 }
 
@@ -69,22 +107,77 @@ func StartHost(port int, privateKey []byte, withDiscovery bool) AkhHost {
 	ps.AddPrivKey(pid, private)
 	ps.AddPubKey(pid, public)
 
-	n, err := swarm.NewNetwork(context.Background(), []ma.Multiaddr{listen}, pid, ps, nil)
+	dir := DataDir(pid)
+	err = os.MkdirAll(dir, 0700)
+	handleStartingHostErr(err)
+
+	var protector ipnet.Protector
+	var fingerprint string
+	if keyPath := viper.GetString("p2p.swarmKey"); keyPath != "" {
+		key, err := ReadSwarmKey(keyPath)
+		handleStartingHostErr(err)
+		protector = &pskProtector{key}
+		fingerprint = fmt.Sprintf("%x", key.Fingerprint())
+		log.Infof("Private network mode, network fingerprint: %s\n", fingerprint)
+	}
+
+	s, err := swarm.NewSwarmWithProtector(context.Background(), []ma.Multiaddr{listen}, pid, ps, protector, nil, nil)
 	handleStartingHostErr(err)
+	n := (*swarm.Network)(s)
 	basicHost := bhost.New(n)
-	akhHost := AkhHost{*basicHost}
+	scores := NewPeerScores(dir, viper.GetInt("p2p.banThreshold"), viper.GetDuration("p2p.banPeriod")*time.Second,
+		viper.GetDuration("p2p.scoreRecovery")*time.Second)
+	addrBook := NewAddrBook(dir)
+	addrBook.startSaving(viper.GetDuration("p2p.addrBookSavePeriod") * time.Second)
+	connManager := NewConnManager(viper.GetInt("p2p.lowWater"), viper.GetInt("p2p.highWater"),
+		viper.GetInt("p2p.bucketSize"), viper.GetDuration("p2p.gracePeriod")*time.Second, scores)
+	timeOffsets := NewTimeOffsets(viper.GetDuration("p2p.maxTimeOffset")*time.Millisecond,
+		viper.GetDuration("p2p.timeOffsetWarning")*time.Millisecond)
+	akhHost := AkhHost{
+		BasicHost:          *basicHost,
+		Scores:             scores,
+		AddrBook:           addrBook,
+		ConnManager:        connManager,
+		NetworkName:        viper.GetString("p2p.network"),
+		NetworkFingerprint: fingerprint,
+		RateLimiter:        newRateLimiterFromConfig(),
+		TimeOffsets:        timeOffsets,
+		metrics:            newHandlerMetrics(),
+		stop:               make(chan struct{}),
+	}
+	akhHost.middleware = newMiddlewareChain(recoverMiddleware, logMiddleware, akhHost.metrics.middleware)
+	akhHost.Outbound = NewOutboundQueues(&akhHost, viper.GetInt("p2p.outboundQueueSize"))
+	n.Notify(&connNotifee{&akhHost})
+	akhHost.startTimeSampling(viper.GetDuration("p2p.timeSamplePeriod") * time.Second)
 
 	if withDiscovery {
 		akhHost.startMdnsDiscovery()
 	}
-	drp := &DiscoverStreamHandler{&ps}
+	drp := &DiscoverStreamHandler{&akhHost}
 	akhHost.AddStreamHandler(drp)
+	srp := &StatusStreamHandler{&akhHost}
+	akhHost.AddStreamHandler(srp)
 
 	log.Infof("Host %s %s on %v started\n", akhHost.ID().Pretty(), akhHost.ID(), []ma.Multiaddr{listen})
 
 	return akhHost
 }
 
+//DataDir returns directory where node with given ID keeps its state
+func DataDir(id peer.ID) string {
+	return filepath.Join(viper.GetString("dataDir"), id.Pretty())
+}
+
+func (h *AkhHost) Close() error {
+	close(h.stop)
+	h.Outbound.Close()
+	err := h.AddrBook.Close()
+	if err != nil {
+		log.Warningf("Failed to save address book: %s\n", err)
+	}
+	return h.BasicHost.Close()
+}
+
 func (h *AkhHost) startMdnsDiscovery() {
 	dnsService, err := discovery.NewMdnsService(context.Background(), &h.BasicHost, 2*time.Minute, "akhcoin")
 	if err != nil {
@@ -105,28 +198,118 @@ func handleStartingHostErr(err error) {
 
 type StreamHandler interface {
 	handle(ws *WrappedStream)
-	protocol() protocol.ID
+	protocol() string //protocol name, e.g. BlockProto
 }
 
+//AddStreamHandler registers handler for all supported versions of its protocol,
+//negotiated version is available to handler with ws.Version()
 func (h *AkhHost) AddStreamHandler(handler StreamHandler) {
-	h.SetStreamHandler(handler.protocol(), func(stream inet.Stream) {
-		log.Debugf("%s: Received %s stream from %s", h.ID().Pretty(), handler.protocol(), stream.Conn().RemotePeer().Pretty())
+	for _, id := range protocolIDs(h.NetworkName, handler.protocol()) {
+		h.SetStreamHandler(id, h.streamHandler(handler))
+	}
+}
+
+func (h *AkhHost) streamHandler(handler StreamHandler) inet.StreamHandler {
+	return func(stream inet.Stream) {
+		remotePeer := stream.Conn().RemotePeer()
+		if h.Scores.IsBanned(remotePeer) {
+			log.Debugf("%s: Rejected %s stream from banned %s", h.ID().Pretty(), stream.Protocol(), remotePeer.Pretty())
+			stream.Reset()
+			h.disconnect(remotePeer)
+			return
+		}
+		name := handler.protocol()
+		if persistentProtocols[name] {
+			//persistent streams are limited by their number and by every message they carry, see admitMessage
+			if !h.RateLimiter.openStream(remotePeer, name) {
+				log.Debugf("%s: Rejected %s stream from %s: too many streams open", h.ID().Pretty(), stream.Protocol(), remotePeer.Pretty())
+				rejectStream(stream, "too many streams open by peer")
+				return
+			}
+			defer h.RateLimiter.closeStream(remotePeer, name)
+		} else {
+			//syncing peer requests blocks one after another, so that they are limited by handlers number only
+			if !syncProtocols[name] {
+				allowed, report := h.RateLimiter.Allow(remotePeer, name)
+				if !allowed {
+					log.Debugf("%s: Rejected %s stream from %s: rate limit exceeded", h.ID().Pretty(), stream.Protocol(), remotePeer.Pretty())
+					rejectStream(stream, "too many streams from peer")
+					if report {
+						h.Penalize(remotePeer, RateLimitViolation)
+					}
+					return
+				}
+			}
+			if !h.RateLimiter.acquire() {
+				log.Warningf("%s: Rejected %s stream from %s: too many streams being handled\n", h.ID().Pretty(), stream.Protocol(), remotePeer.Pretty())
+				rejectStream(stream, "too many streams being handled")
+				return
+			}
+			defer h.RateLimiter.release()
+		}
 		ws := WrapStream(stream)
+		ws.host = h
 		defer stream.Close()
-		handler.handle(ws)
-		log.Debugf("%s: %s stream from %s processing finished", h.ID().Pretty(), handler.protocol(), stream.Conn().RemotePeer().Pretty())
-	})
+		h.middleware.wrap(stream.Protocol(), handler.handle)(ws)
+	}
+}
+
+//admitMessage applies rate limits to the message received over persistent stream, rejected message is answered
+//with StatusRateLimited. Handler slot is taken while the message is handled only, release frees it.
+func (ws *WrappedStream) admitMessage() (release func(), admitted bool) {
+	if ws.host == nil {
+		return func() {}, true
+	}
+	h := ws.host
+	remotePeer := ws.RemotePeer()
+	_, name, _, _ := ParseProtocolID(ws.Protocol())
+	allowed, report := h.RateLimiter.Allow(remotePeer, name)
+	if !allowed {
+		log.Debugf("%s: Rejected %s message from %s: rate limit exceeded", h.ID().Pretty(), ws.Protocol(), remotePeer.Pretty())
+		sendResponse(ws, StatusRateLimited, "too many messages from peer")
+		if report {
+			h.Penalize(remotePeer, RateLimitViolation)
+		}
+		return nil, false
+	}
+	if !h.RateLimiter.acquire() {
+		log.Warningf("%s: Rejected %s message from %s: too many messages being handled\n", h.ID().Pretty(), ws.Protocol(), remotePeer.Pretty())
+		sendResponse(ws, StatusRateLimited, "too many messages being handled")
+		return nil, false
+	}
+	return h.RateLimiter.release, true
+}
+
+//handleMessage responds to the message of persistent stream with result of its processing if message is admitted,
+//handler slot is released even if processing panics
+func (ws *WrappedStream) handleMessage(process func() error) error {
+	release, admitted := ws.admitMessage()
+	if !admitted {
+		return nil
+	}
+	defer release()
+	return respond(ws, process())
+}
+
+//rejectStream tells remote side it was rate limited, if it still listens, and aborts the stream
+func rejectStream(stream inet.Stream, reason string) {
+	stream.SetWriteDeadline(time.Now().Add(time.Second))
+	sendResponse(WrapStream(stream), StatusRateLimited, reason)
+	stream.Reset()
 }
 
-func (h *AkhHost) ask(peerID peer.ID, question Message, proto protocol.ID, answer interface{}) (err error) {
+func (h *AkhHost) ask(peerID peer.ID, question Message, proto string, answer interface{}) (err error) {
 	ws, err := h.SendMessage(&question, peerID, proto)
 	if err != nil {
 		return
 	}
-	err = receiveMessage(&answer, ws)
+	defer ws.stream.Close()
+	err = receiveResponse(ws)
+	if err == nil {
+		err = receiveMessage(&answer, ws)
+	}
 	if err != nil {
 		err = fmt.Errorf("%s: %s stream to %s processing ended: %s", h.ID(), proto, peerID, err)
-
 	}
 	return
 }
@@ -134,31 +317,49 @@ func (h *AkhHost) ask(peerID peer.ID, question Message, proto protocol.ID, answe
 func answer(ws *WrappedStream, question Message, getAnswer func() interface{}) (err error) {
 	err = receiveMessage(&question, ws)
 	if err != nil {
+		sendResponse(ws, StatusRejected, MalformedMessage.String())
 		err = fmt.Errorf("Failed to decode stream: %s\n", err)
 		return
 	}
 
-	err = sendMessage(getAnswer(), ws)
+	err = sendResponse(ws, StatusOK, "")
+	if err == nil {
+		err = sendMessage(getAnswer(), ws)
+	}
 	if err != nil {
 		err = fmt.Errorf("%s: Failed to transmit peer info: %s\n", ws.stream.Conn().RemotePeer(), err)
 	}
 	return
 }
 
-func (h *AkhHost) SendMessage(msg interface{}, peerID peer.ID, proto protocol.ID) (ws *WrappedStream, err error) {
-	stream, err := h.NewStream(context.Background(), peerID, proto)
+//SendMessage opens stream of the highest protocol version both peers support and sends the message
+func (h *AkhHost) SendMessage(msg interface{}, peerID peer.ID, proto string) (ws *WrappedStream, err error) {
+	stream, err := h.newStream(context.Background(), peerID, proto)
 	if err != nil {
 		return
 	}
 	ws = WrapStream(stream)
-	sendMessage(msg, ws)
+	err = sendMessage(msg, ws)
+	if err != nil {
+		stream.Reset()
+	}
 	return
 }
 
+//penalize reports misbehavior of the remote peer, has effect for incoming streams only
+func (ws *WrappedStream) penalize(m Misbehavior) {
+	if ws.host != nil {
+		ws.host.Penalize(ws.stream.Conn().RemotePeer(), m)
+	}
+}
+
 func sendMessage(msg interface{}, ws *WrappedStream) (err error) {
 	err = ws.enc.Encode(msg)
+	if err != nil {
+		return
+	}
 	// Because output is buffered with bufio, we need to flush!
-	ws.w.Flush()
+	err = ws.w.Flush()
 	return
 }
 
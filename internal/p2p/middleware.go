@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/libp2p/go-libp2p-protocol"
+)
+
+//HandlerFunc processes incoming stream of some protocol
+type HandlerFunc func(ws *WrappedStream)
+
+//Middleware wraps handler of given protocol with extra processing, e.g. auth or tracing.
+//It may either call next or reset the stream.
+type Middleware func(proto protocol.ID, next HandlerFunc) HandlerFunc
+
+type middlewareChain struct {
+	middlewares []Middleware
+	sync.RWMutex
+}
+
+func newMiddlewareChain(middlewares ...Middleware) *middlewareChain {
+	return &middlewareChain{middlewares: middlewares}
+}
+
+func (c *middlewareChain) use(m ...Middleware) {
+	c.Lock()
+	defer c.Unlock()
+	c.middlewares = append(c.middlewares, m...)
+}
+
+//wrap applies middlewares to handler, the first added is the outermost
+func (c *middlewareChain) wrap(proto protocol.ID, handler HandlerFunc) HandlerFunc {
+	c.RLock()
+	defer c.RUnlock()
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](proto, handler)
+	}
+	return handler
+}
+
+//Use adds middleware to all incoming streams handlers, it goes after built-in recovery, logging and metrics ones
+func (h *AkhHost) Use(m ...Middleware) {
+	h.middleware.use(m...)
+}
+
+//recoverMiddleware resets the stream instead of crashing the node if handler panics
+func recoverMiddleware(proto protocol.ID, next HandlerFunc) HandlerFunc {
+	return func(ws *WrappedStream) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("%s handler panicked on stream from %s: %v\n%s", proto, ws.RemotePeer().Pretty(), r, debug.Stack())
+				ws.Reset()
+			}
+		}()
+		next(ws)
+	}
+}
+
+//logMiddleware logs every handled stream in key=value format
+func logMiddleware(proto protocol.ID, next HandlerFunc) HandlerFunc {
+	return func(ws *WrappedStream) {
+		start := time.Now()
+		log.Debugf("stream started proto=%s peer=%s\n", proto, ws.RemotePeer().Pretty())
+		next(ws)
+		log.Debugf("stream finished proto=%s peer=%s duration=%s in=%d out=%d\n",
+			proto, ws.RemotePeer().Pretty(), time.Since(start), ws.BytesRead(), ws.BytesWritten())
+	}
+}
+
+//ProtocolStats is incoming streams statistics of a protocol
+type ProtocolStats struct {
+	Streams      int
+	Panics       int
+	TotalTime    time.Duration
+	MaxTime      time.Duration
+	BytesRead    int64
+	BytesWritten int64
+}
+
+func (s ProtocolStats) AvgTime() time.Duration {
+	if s.Streams == 0 {
+		return 0
+	}
+	return s.TotalTime / time.Duration(s.Streams)
+}
+
+func (s ProtocolStats) String() string {
+	return fmt.Sprintf("streams: %d, panics: %d, avg time: %s, max time: %s, read: %d B, written: %d B",
+		s.Streams, s.Panics, s.AvgTime(), s.MaxTime, s.BytesRead, s.BytesWritten)
+}
+
+type handlerMetrics struct {
+	stats map[protocol.ID]*ProtocolStats
+	sync.Mutex
+}
+
+func newHandlerMetrics() *handlerMetrics {
+	return &handlerMetrics{stats: make(map[protocol.ID]*ProtocolStats)}
+}
+
+//middleware collects per protocol latency and byte counters, panics are counted but not recovered
+func (m *handlerMetrics) middleware(proto protocol.ID, next HandlerFunc) HandlerFunc {
+	return func(ws *WrappedStream) {
+		start := time.Now()
+		panicked := true
+		defer func() {
+			m.record(proto, time.Since(start), ws.BytesRead(), ws.BytesWritten(), panicked)
+		}()
+		next(ws)
+		panicked = false
+	}
+}
+
+func (m *handlerMetrics) record(proto protocol.ID, duration time.Duration, read, written int64, panicked bool) {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.stats[proto]
+	if !ok {
+		s = &ProtocolStats{}
+		m.stats[proto] = s
+	}
+	s.Streams++
+	if panicked {
+		s.Panics++
+	}
+	s.TotalTime += duration
+	if duration > s.MaxTime {
+		s.MaxTime = duration
+	}
+	s.BytesRead += read
+	s.BytesWritten += written
+}
+
+func (m *handlerMetrics) snapshot() map[protocol.ID]ProtocolStats {
+	m.Lock()
+	defer m.Unlock()
+	stats := make(map[protocol.ID]ProtocolStats, len(m.stats))
+	for proto, s := range m.stats {
+		stats[proto] = *s
+	}
+	return stats
+}
+
+//HandlerStats returns incoming streams statistics by protocol
+func (h *AkhHost) HandlerStats() map[protocol.ID]ProtocolStats {
+	return h.metrics.snapshot()
+}
+
+//countingStream counts bytes passed through the stream
+type countingStream struct {
+	inet.Stream
+	read    int64
+	written int64
+}
+
+func (s *countingStream) Read(b []byte) (n int, err error) {
+	n, err = s.Stream.Read(b)
+	atomic.AddInt64(&s.read, int64(n))
+	return
+}
+
+func (s *countingStream) Write(b []byte) (n int, err error) {
+	n, err = s.Stream.Write(b)
+	atomic.AddInt64(&s.written, int64(n))
+	return
+}
+
+func (ws *WrappedStream) BytesRead() int64 {
+	return atomic.LoadInt64(&ws.counter.read)
+}
+
+func (ws *WrappedStream) BytesWritten() int64 {
+	return atomic.LoadInt64(&ws.counter.written)
+}
+
+func (ws *WrappedStream) RemotePeer() peer.ID {
+	return ws.stream.Conn().RemotePeer()
+}
+
+func (ws *WrappedStream) Protocol() protocol.ID {
+	return ws.stream.Protocol()
+}
+
+//Reset aborts the stream, remote side gets an error
+func (ws *WrappedStream) Reset() error {
+	return ws.stream.Reset()
+}
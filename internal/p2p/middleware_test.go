@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"testing"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/libp2p/go-libp2p-protocol"
+)
+
+type fakeConn struct {
+	inet.Conn
+}
+
+func (fakeConn) RemotePeer() peer.ID {
+	return peer.ID("remote")
+}
+
+type fakeStream struct {
+	inet.Stream
+	reset bool
+}
+
+func (s *fakeStream) Conn() inet.Conn {
+	return fakeConn{}
+}
+
+func (s *fakeStream) Reset() error {
+	s.reset = true
+	return nil
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var calls []string
+	tracing := func(name string) Middleware {
+		return func(proto protocol.ID, next HandlerFunc) HandlerFunc {
+			return func(ws *WrappedStream) {
+				calls = append(calls, name)
+				next(ws)
+			}
+		}
+	}
+	chain := newMiddlewareChain(tracing("first"))
+	chain.use(tracing("second"))
+
+	chain.wrap(StatusProto, func(ws *WrappedStream) {
+		calls = append(calls, "handler")
+	})(&WrappedStream{})
+
+	expected := []string{"first", "second", "handler"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestRecoveryAndMetrics(t *testing.T) {
+	metrics := newHandlerMetrics()
+	chain := newMiddlewareChain(recoverMiddleware, metrics.middleware)
+	stream := &fakeStream{}
+	ws := &WrappedStream{stream: stream, counter: &countingStream{read: 10, written: 20}}
+
+	chain.wrap(BlockProto, func(ws *WrappedStream) {
+		panic("handler failure")
+	})(ws)
+	chain.wrap(BlockProto, func(ws *WrappedStream) {})(ws)
+
+	if !stream.reset {
+		t.Fatal("stream of panicked handler not reset")
+	}
+	stats := metrics.snapshot()[BlockProto]
+	if stats.Streams != 2 || stats.Panics != 1 {
+		t.Fatalf("expected 2 streams with 1 panic, got %+v", stats)
+	}
+	if stats.BytesRead != 20 || stats.BytesWritten != 40 {
+		t.Fatalf("unexpected bytes counters %+v", stats)
+	}
+}
@@ -0,0 +1,249 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/spf13/viper"
+)
+
+//Outbound messages priorities, lower value goes first
+const (
+	blockPriority = iota
+	votePriority
+	transactionPriority
+	prioritiesNumber
+)
+
+var ErrQueueFull = fmt.Errorf("outbound queue is full")
+
+func priorityOf(proto string) int {
+	switch proto {
+	case BlockAnnounceProto:
+		return blockPriority
+	case VoteAnnounceProto, EvidenceProto:
+		return votePriority
+	}
+	return transactionPriority
+}
+
+//QueueStats is delivery statistics of the peer outbound queue
+type QueueStats struct {
+	Queued  int
+	Sent    int
+	Dropped int
+	Failed  int
+	LastErr error
+}
+
+type outboundMessage struct {
+	proto string
+	msg   interface{}
+}
+
+type messageSender interface {
+	send(proto string, msg interface{}) error
+	close()
+}
+
+//OutboundQueues keeps long-lived queue for every peer messages are published to.
+//Blocks are delivered before votes and votes before transactions. When queue is full, votes and transactions are
+//dropped, while peer not able to keep up with blocks is disconnected.
+type OutboundQueues struct {
+	queues    map[peer.ID]*peerQueue
+	size      int
+	newSender func(id peer.ID) messageSender
+	onFull    func(id peer.ID)
+	sync.Mutex
+}
+
+func NewOutboundQueues(h *AkhHost, size int) *OutboundQueues {
+	return &OutboundQueues{
+		queues: make(map[peer.ID]*peerQueue),
+		size:   size,
+		newSender: func(id peer.ID) messageSender {
+			return &streamSender{
+				h:               h,
+				id:              id,
+				streams:         make(map[string]*WrappedStream),
+				responseTimeout: time.Duration(viper.GetInt("p2p.responseTimeout")) * time.Second,
+			}
+		},
+		onFull: func(id peer.ID) {
+			log.Warningf("%s: %s can't keep up with blocks, disconnecting\n", h.ID().Pretty(), id.Pretty())
+			h.Network().ClosePeer(id)
+		},
+	}
+}
+
+func (o *OutboundQueues) Enqueue(id peer.ID, proto string, msg interface{}) (err error) {
+	o.Lock()
+	q, ok := o.queues[id]
+	if !ok {
+		q = newPeerQueue(id, o.size, o.newSender(id))
+		o.queues[id] = q
+	}
+	o.Unlock()
+
+	priority := priorityOf(proto)
+	err = q.enqueue(priority, outboundMessage{proto, msg})
+	if err == ErrQueueFull && priority == blockPriority {
+		o.Remove(id)
+		o.onFull(id)
+	}
+	return
+}
+
+//Remove stops peer queue, messages not delivered yet are discarded
+func (o *OutboundQueues) Remove(id peer.ID) {
+	o.Lock()
+	q, ok := o.queues[id]
+	delete(o.queues, id)
+	o.Unlock()
+	if ok {
+		q.close()
+	}
+}
+
+func (o *OutboundQueues) Stats() map[peer.ID]QueueStats {
+	o.Lock()
+	defer o.Unlock()
+	stats := make(map[peer.ID]QueueStats, len(o.queues))
+	for id, q := range o.queues {
+		stats[id] = q.getStats()
+	}
+	return stats
+}
+
+func (o *OutboundQueues) Close() {
+	o.Lock()
+	queues := o.queues
+	o.queues = make(map[peer.ID]*peerQueue)
+	o.Unlock()
+	for _, q := range queues {
+		q.close()
+	}
+}
+
+type peerQueue struct {
+	id     peer.ID
+	queues [prioritiesNumber]chan outboundMessage
+	sender messageSender
+	stop   chan struct{}
+	once   sync.Once
+	stats  QueueStats
+	sync.Mutex
+}
+
+func newPeerQueue(id peer.ID, size int, sender messageSender) *peerQueue {
+	q := &peerQueue{id: id, sender: sender, stop: make(chan struct{})}
+	for i := range q.queues {
+		q.queues[i] = make(chan outboundMessage, size)
+	}
+	go q.run()
+	return q
+}
+
+func (q *peerQueue) enqueue(priority int, m outboundMessage) error {
+	select {
+	case q.queues[priority] <- m:
+		q.updateStats(func(s *QueueStats) { s.Queued++ })
+		return nil
+	default:
+		q.updateStats(func(s *QueueStats) { s.Dropped++ })
+		return ErrQueueFull
+	}
+}
+
+func (q *peerQueue) run() {
+	defer q.sender.close()
+	for {
+		m, ok := q.next()
+		if !ok {
+			return
+		}
+		err := q.sender.send(m.proto, m.msg)
+		if err != nil {
+			log.Warningf("Error delivering %T to %s: %s\n", m.msg, q.id.Pretty(), err)
+			q.updateStats(func(s *QueueStats) { s.Failed++; s.LastErr = err })
+			continue
+		}
+		q.updateStats(func(s *QueueStats) { s.Sent++ })
+	}
+}
+
+//next returns message of the highest priority available, waits if there are none
+func (q *peerQueue) next() (m outboundMessage, ok bool) {
+	for _, queue := range q.queues {
+		select {
+		case m = <-queue:
+			return m, true
+		default:
+		}
+	}
+	select {
+	case m = <-q.queues[blockPriority]:
+	case m = <-q.queues[votePriority]:
+	case m = <-q.queues[transactionPriority]:
+	case <-q.stop:
+		return m, false
+	}
+	return m, true
+}
+
+func (q *peerQueue) close() {
+	q.once.Do(func() { close(q.stop) })
+}
+
+func (q *peerQueue) updateStats(update func(s *QueueStats)) {
+	q.Lock()
+	defer q.Unlock()
+	update(&q.stats)
+}
+
+func (q *peerQueue) getStats() QueueStats {
+	q.Lock()
+	defer q.Unlock()
+	return q.stats
+}
+
+//streamSender delivers messages to the peer reusing one stream per protocol
+type streamSender struct {
+	h               *AkhHost
+	id              peer.ID
+	streams         map[string]*WrappedStream
+	responseTimeout time.Duration
+}
+
+func (s *streamSender) send(proto string, msg interface{}) (err error) {
+	ws, ok := s.streams[proto]
+	if !ok {
+		stream, err := s.h.newStream(context.Background(), s.id, proto)
+		if err != nil {
+			return err
+		}
+		ws = WrapStream(stream)
+		s.streams[proto] = ws
+	}
+	err = sendMessage(msg, ws)
+	if err == nil {
+		//peer not answering must not stall the queue
+		ws.stream.SetReadDeadline(time.Now().Add(s.responseTimeout))
+		err = receiveResponse(ws)
+	}
+	if err != nil && !isRejection(err) {
+		ws.stream.Reset()
+		delete(s.streams, proto)
+	}
+	return
+}
+
+func (s *streamSender) close() {
+	for proto, ws := range s.streams {
+		ws.stream.Close()
+		delete(s.streams, proto)
+	}
+}
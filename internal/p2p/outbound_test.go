@@ -0,0 +1,113 @@
+package p2p
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+type recordingSender struct {
+	sent    []string
+	blocked chan struct{}
+	sync.Mutex
+}
+
+func (s *recordingSender) send(proto string, msg interface{}) error {
+	<-s.blocked
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, proto)
+	return nil
+}
+
+func (s *recordingSender) close() {}
+
+func (s *recordingSender) getSent() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+func newTestQueues(size int, sender messageSender) (o *OutboundQueues, full chan peer.ID) {
+	full = make(chan peer.ID, 1)
+	o = &OutboundQueues{
+		queues:    make(map[peer.ID]*peerQueue),
+		size:      size,
+		newSender: func(id peer.ID) messageSender { return sender },
+		onFull:    func(id peer.ID) { full <- id },
+	}
+	return
+}
+
+func TestOutboundPriorities(t *testing.T) {
+	sender := &recordingSender{blocked: make(chan struct{})}
+	o, _ := newTestQueues(4, sender)
+	defer o.Close()
+	id := peer.ID("peer")
+
+	//first message is taken by sender immediately and blocks it, the rest wait in queues
+	o.Enqueue(id, TransactionProto, "t0")
+	time.Sleep(10 * time.Millisecond)
+	o.Enqueue(id, TransactionProto, "t1")
+	o.Enqueue(id, VoteAnnounceProto, "v")
+	o.Enqueue(id, BlockAnnounceProto, "b")
+	close(sender.blocked)
+
+	expected := []string{TransactionProto, BlockAnnounceProto, VoteAnnounceProto, TransactionProto}
+	for i := 0; i < 100 && len(sender.getSent()) < len(expected); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := sender.getSent()
+	if len(sent) != len(expected) {
+		t.Fatalf("expected %d messages sent, got %d", len(expected), len(sent))
+	}
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("message %d: expected %s, got %s", i, expected[i], sent[i])
+		}
+	}
+	if stats := o.Stats()[id]; stats.Sent != len(expected) || stats.Dropped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestOutboundQueueFull(t *testing.T) {
+	sender := &recordingSender{blocked: make(chan struct{})}
+	defer close(sender.blocked)
+	o, full := newTestQueues(1, sender)
+	defer o.Close()
+	id := peer.ID("peer")
+
+	o.Enqueue(id, TransactionProto, "t0")
+	time.Sleep(10 * time.Millisecond)
+	if err := o.Enqueue(id, TransactionProto, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Enqueue(id, TransactionProto, "t2"); err != ErrQueueFull {
+		t.Fatalf("expected %s, got %v", ErrQueueFull, err)
+	}
+	if stats := o.Stats()[id]; stats.Dropped != 1 {
+		t.Fatalf("expected 1 dropped message, got %d", stats.Dropped)
+	}
+	select {
+	case <-full:
+		t.Fatal("peer disconnected because of transactions")
+	default:
+	}
+
+	o.Enqueue(id, BlockAnnounceProto, "b0")
+	o.Enqueue(id, BlockAnnounceProto, "b1")
+	select {
+	case disconnected := <-full:
+		if disconnected != id {
+			t.Fatalf("unexpected peer %s disconnected", disconnected)
+		}
+	default:
+		t.Fatal("slow peer not disconnected on full block queue")
+	}
+	if _, ok := o.Stats()[id]; ok {
+		t.Fatal("queue of disconnected peer not removed")
+	}
+}
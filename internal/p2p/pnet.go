@@ -0,0 +1,189 @@
+package p2p
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	tpt "github.com/libp2p/go-libp2p-transport"
+	"golang.org/x/crypto/salsa20"
+)
+
+//Swarm key file format is compatible with libp2p private networks:
+//	/key/swarm/psk/1.0.0/
+//	/base16/
+//	<64 hex digits>
+const (
+	swarmKeyHeader   = "/key/swarm/psk/1.0.0/"
+	swarmKeyEncoding = "/base16/"
+	pskNonceSize     = 24
+)
+
+//SwarmKey is pre-shared key of private network, only peers holding the same key can connect to each other
+type SwarmKey [32]byte
+
+func ReadSwarmKey(path string) (key *SwarmKey, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return parseSwarmKey(file)
+}
+
+func parseSwarmKey(r io.Reader) (key *SwarmKey, err error) {
+	scanner := bufio.NewScanner(r)
+	lines := make([]string, 0, 3)
+	for scanner.Scan() && len(lines) < 3 {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if len(lines) < 3 || lines[0] != swarmKeyHeader || lines[1] != swarmKeyEncoding {
+		return nil, fmt.Errorf("swarm key has to be in format %s %s <hex>", swarmKeyHeader, swarmKeyEncoding)
+	}
+	bytes, err := hex.DecodeString(lines[2])
+	if err != nil {
+		return
+	}
+	if len(bytes) != len(SwarmKey{}) {
+		return nil, fmt.Errorf("swarm key has to be %d bytes long, got %d", len(SwarmKey{}), len(bytes))
+	}
+	key = new(SwarmKey)
+	copy(key[:], bytes)
+	return
+}
+
+//GenerateSwarmKey returns contents of new random swarm key file
+func GenerateSwarmKey() (string, error) {
+	var key SwarmKey
+	_, err := rand.Read(key[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\n%s\n%x\n", swarmKeyHeader, swarmKeyEncoding, key[:]), nil
+}
+
+//Fingerprint identifies private network without disclosing its key
+func (key *SwarmKey) Fingerprint() []byte {
+	hash := sha256.Sum256(append([]byte("akhcoin private network fingerprint"), key[:]...))
+	return hash[:16]
+}
+
+//pskProtector encrypts every connection with the swarm key, so that handshake with peer not holding it fails
+type pskProtector struct {
+	key *SwarmKey
+}
+
+func (p *pskProtector) Protect(conn tpt.Conn) (tpt.Conn, error) {
+	rw, err := newPSKReadWriter(conn, p.key)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &pskConn{conn, rw}, nil
+}
+
+func (p *pskProtector) Fingerprint() []byte {
+	return p.key.Fingerprint()
+}
+
+type pskConn struct {
+	tpt.Conn
+	rw *pskReadWriter
+}
+
+func (c *pskConn) Read(b []byte) (int, error) {
+	return c.rw.Read(b)
+}
+
+func (c *pskConn) Write(b []byte) (int, error) {
+	return c.rw.Write(b)
+}
+
+//pskReadWriter exchanges random nonces with the remote side, then XSalsa20 encrypts everything written with own nonce
+//and decrypts everything read with the remote one
+type pskReadWriter struct {
+	rw     io.ReadWriter
+	reader *keyStream
+	writer *keyStream
+}
+
+func newPSKReadWriter(rw io.ReadWriter, key *SwarmKey) (*pskReadWriter, error) {
+	nonce := make([]byte, pskNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(nonce)
+		writeErr <- err
+	}()
+	remoteNonce := make([]byte, pskNonceSize)
+	_, err = io.ReadFull(rw, remoteNonce)
+	if err != nil {
+		return nil, fmt.Errorf("private network handshake failed: %s", err)
+	}
+	if err = <-writeErr; err != nil {
+		return nil, fmt.Errorf("private network handshake failed: %s", err)
+	}
+
+	return &pskReadWriter{
+		rw:     rw,
+		reader: newKeyStream(key, remoteNonce),
+		writer: newKeyStream(key, nonce),
+	}, nil
+}
+
+func (p *pskReadWriter) Read(b []byte) (n int, err error) {
+	n, err = p.rw.Read(b)
+	p.reader.xorKeyStream(b[:n], b[:n])
+	return
+}
+
+func (p *pskReadWriter) Write(b []byte) (int, error) {
+	encrypted := make([]byte, len(b))
+	p.writer.xorKeyStream(encrypted, b)
+	return p.rw.Write(encrypted)
+}
+
+//keyStreamChunk is size of key stream generated with one nonce
+const keyStreamChunk = 4096
+
+//keyStream is XSalsa20 key stream generated by chunks, nonce of every next chunk is the previous one incremented,
+//so that nonces never repeat within connection
+type keyStream struct {
+	key   [32]byte
+	nonce [pskNonceSize]byte
+	chunk [keyStreamChunk]byte
+	used  int
+}
+
+func newKeyStream(key *SwarmKey, nonce []byte) *keyStream {
+	s := &keyStream{key: *key, used: keyStreamChunk}
+	copy(s.nonce[:], nonce)
+	return s
+}
+
+func (s *keyStream) xorKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == keyStreamChunk {
+			var zeros [keyStreamChunk]byte
+			salsa20.XORKeyStream(s.chunk[:], zeros[:], s.nonce[:], &s.key)
+			//the last 8 bytes of XSalsa20 nonce are Salsa20 nonce of the derived key
+			binary.LittleEndian.PutUint64(s.nonce[16:], binary.LittleEndian.Uint64(s.nonce[16:])+1)
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.chunk[s.used]
+		s.used++
+	}
+}
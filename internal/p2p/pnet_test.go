@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseSwarmKey(t *testing.T) {
+	contents, _ := GenerateSwarmKey()
+	key, err := parseSwarmKey(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := GenerateSwarmKey()
+	otherKey, _ := parseSwarmKey(strings.NewReader(other))
+	if bytes.Equal(key.Fingerprint(), otherKey.Fingerprint()) {
+		t.Fatal("different keys have the same fingerprint")
+	}
+
+	_, err = parseSwarmKey(strings.NewReader("/key/swarm/psk/1.0.0/\n/base16/\n0011"))
+	if err == nil {
+		t.Fatal("short key accepted")
+	}
+}
+
+func TestPSKReadWriter(t *testing.T) {
+	contents, _ := GenerateSwarmKey()
+	key, _ := parseSwarmKey(strings.NewReader(contents))
+	other, _ := GenerateSwarmKey()
+	otherKey, _ := parseSwarmKey(strings.NewReader(other))
+
+	msg := bytes.Repeat([]byte("akhcoin"), 1500) //longer than key stream chunk
+
+	if received := exchangeOverPSK(t, key, key, msg); !bytes.Equal(received, msg) {
+		t.Fatal("message corrupted between peers holding the same key")
+	}
+	if received := exchangeOverPSK(t, key, otherKey, msg); bytes.Equal(received, msg) {
+		t.Fatal("message readable by peer holding different key")
+	}
+}
+
+func exchangeOverPSK(t *testing.T, senderKey, receiverKey *SwarmKey, msg []byte) []byte {
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	sent := make(chan error, 1)
+	go func() {
+		sender, err := newPSKReadWriter(senderConn, senderKey)
+		if err == nil {
+			//split writes to check key stream continuity between blocks
+			_, err = sender.Write(msg[:100])
+		}
+		if err == nil {
+			_, err = sender.Write(msg[100:])
+		}
+		sent <- err
+	}()
+
+	receiver, err := newPSKReadWriter(receiverConn, receiverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len(msg))
+	_, err = io.ReadFull(receiver, received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
@@ -0,0 +1,113 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/libp2p/go-libp2p-protocol"
+)
+
+//Protocol IDs have format /akhcoin/<network>/<name>/<semver>, so that nodes of different networks never talk
+//and nodes of the same network may support several versions of each protocol at once
+const protocolsPrefix = "/akhcoin/"
+
+//Protocol names
+const (
+	BlockProto         = "block"
+	TransactionProto   = "transaction"
+	BlockAnnounceProto = "blockAnnounce"
+	DiscoverProto      = "discover"
+	VoteAnnounceProto  = "vote"
+	StatusProto        = "status"
+	EvidenceProto      = "evidence"
+)
+
+//protocolVersions lists versions of every protocol this node supports, the newest first.
+//During an upgrade, new version goes first while the old one is kept until the network moves on.
+//Versions carrying differently signed messages can't interoperate and aren't kept, e.g. blocks are signed with
+//domain tag since block and blockAnnounce 3.0.0, votes are signed with withdrawal flag byte since vote 3.0.0.
+var protocolVersions = map[string][]string{
+	BlockProto:         {"3.0.0"},
+	TransactionProto:   {"2.0.0", "1.0.0"},
+	BlockAnnounceProto: {"3.0.0"},
+	DiscoverProto:      {"3.0.0", "2.0.0"},
+	VoteAnnounceProto:  {"3.0.0"},
+	StatusProto:        {"2.0.0", "1.0.0"},
+	EvidenceProto:      {"1.0.0"},
+}
+
+//legacyVersions are the last versions of protocols without Response envelopes, kept for not yet upgraded peers
+var legacyVersions = map[string]string{
+	TransactionProto: "1.0.0",
+	DiscoverProto:    "2.0.0",
+	StatusProto:      "1.0.0",
+}
+
+//hasEnvelope tells whether requests and announcements are answered with Response in the protocol version
+func hasEnvelope(id protocol.ID) bool {
+	_, name, version, err := ParseProtocolID(id)
+	if err != nil {
+		return true
+	}
+	legacy, ok := legacyVersions[name]
+	return !ok || legacy != version
+}
+
+//persistentProtocols keep stream open for many messages, announcements are sent over them
+var persistentProtocols = map[string]bool{
+	TransactionProto:   true,
+	BlockAnnounceProto: true,
+	VoteAnnounceProto:  true,
+	EvidenceProto:      true,
+}
+
+//syncProtocols are requested by syncing peers many times in a row, they aren't limited per peer
+var syncProtocols = map[string]bool{
+	BlockProto: true,
+}
+
+func ProtocolID(network string, name string, version string) protocol.ID {
+	return protocol.ID(protocolsPrefix + network + "/" + name + "/" + version)
+}
+
+//ParseProtocolID splits protocol ID to its network, name and version
+func ParseProtocolID(id protocol.ID) (network string, name string, version string, err error) {
+	if !strings.HasPrefix(string(id), protocolsPrefix) {
+		err = fmt.Errorf("%s is not akhcoin protocol", id)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(string(id), protocolsPrefix), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		err = fmt.Errorf("protocol ID %s has to be in format %s<network>/<name>/<version>", id, protocolsPrefix)
+		return
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+//protocolIDs returns IDs of all supported versions of the protocol, the newest first
+func protocolIDs(network string, name string) []protocol.ID {
+	versions := protocolVersions[name]
+	ids := make([]protocol.ID, len(versions))
+	for i, version := range versions {
+		ids[i] = ProtocolID(network, name, version)
+	}
+	return ids
+}
+
+//newStream opens stream of the highest protocol version supported by both sides
+func (h *AkhHost) newStream(ctx context.Context, peerID peer.ID, name string) (inet.Stream, error) {
+	ids := protocolIDs(h.NetworkName, name)
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("unknown protocol %s", name)
+	}
+	return h.NewStream(ctx, peerID, ids...)
+}
+
+//Version returns negotiated version of the stream protocol
+func (ws *WrappedStream) Version() string {
+	_, _, version, _ := ParseProtocolID(ws.Protocol())
+	return version
+}
@@ -0,0 +1,50 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-protocol"
+)
+
+func TestProtocolID(t *testing.T) {
+	id := ProtocolID("testnet", BlockAnnounceProto, "1.2.0")
+	if id != "/akhcoin/testnet/blockAnnounce/1.2.0" {
+		t.Fatalf("unexpected protocol ID %s", id)
+	}
+	network, name, version, err := ParseProtocolID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network != "testnet" || name != BlockAnnounceProto || version != "1.2.0" {
+		t.Fatalf("%s parsed to %s %s %s", id, network, name, version)
+	}
+
+	for _, invalid := range []string{"ip4/akhcoin.org/tcp/block/1.0.0", "/akhcoin/block/1.0.0", "/akhcoin/main//1.0.0"} {
+		if _, _, _, err = ParseProtocolID(protocol.ID(invalid)); err == nil {
+			t.Errorf("invalid protocol ID %s parsed", invalid)
+		}
+	}
+}
+
+func TestProtocolIDsOrder(t *testing.T) {
+	saved := protocolVersions[DiscoverProto]
+	defer func() { protocolVersions[DiscoverProto] = saved }()
+	protocolVersions[DiscoverProto] = []string{"3.0.0", "2.0.0"}
+
+	ids := protocolIDs("main", DiscoverProto)
+	if len(ids) != 2 || ids[0] != ProtocolID("main", DiscoverProto, "3.0.0") || ids[1] != ProtocolID("main", DiscoverProto, "2.0.0") {
+		t.Fatalf("unexpected protocol IDs %v", ids)
+	}
+	if ids = protocolIDs("main", "unknown"); len(ids) != 0 {
+		t.Fatalf("IDs of unknown protocol %v", ids)
+	}
+}
+
+func TestHasEnvelope(t *testing.T) {
+	if hasEnvelope(ProtocolID("main", TransactionProto, "1.0.0")) || hasEnvelope(ProtocolID("main", DiscoverProto, "2.0.0")) {
+		t.Error("legacy protocol version expects response envelope")
+	}
+	if !hasEnvelope(ProtocolID("main", BlockAnnounceProto, "3.0.0")) || !hasEnvelope(ProtocolID("main", EvidenceProto, "1.0.0")) {
+		t.Error("protocol version without response envelope")
+	}
+}
@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/spf13/viper"
+)
+
+const rateLimiterPrunePeriod = time.Minute
+
+//RateLimit is allowed number of incoming streams or messages of persistent streams per second, with burst of them
+//allowed at once
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type bucketKey struct {
+	id    peer.ID
+	proto string
+}
+
+//RateLimiter limits incoming streams, or messages of persistent streams, of every peer per protocol with token buckets
+//and number of them handled concurrently by all peers together. Persistent streams open at once are limited
+//for every peer per protocol too.
+//Peer exceeding its limits maxViolations times gets reported, violations are forgotten once peer buckets refill.
+type RateLimiter struct {
+	limit         RateLimit
+	protoLimits   map[string]RateLimit //by protocol name, e.g. "transaction"
+	buckets       map[bucketKey]*tokenBucket
+	violations    map[peer.ID]int
+	maxViolations int
+	handlers      chan struct{}
+	streams       map[bucketKey]int //persistent streams open
+	maxStreams    int
+	pruned        time.Time
+	now           func() time.Time
+	sync.Mutex
+}
+
+func NewRateLimiter(limit RateLimit, protoLimits map[string]RateLimit, maxHandlers int, maxViolations int,
+	maxStreams int) *RateLimiter {
+	return &RateLimiter{
+		limit:         limit,
+		protoLimits:   protoLimits,
+		buckets:       make(map[bucketKey]*tokenBucket),
+		violations:    make(map[peer.ID]int),
+		maxViolations: maxViolations,
+		handlers:      make(chan struct{}, maxHandlers),
+		streams:       make(map[bucketKey]int),
+		maxStreams:    maxStreams,
+		now:           time.Now,
+	}
+}
+
+//Allow takes token from the peer bucket of given protocol,
+//report is true when peer should be penalized for repeated violations
+func (r *RateLimiter) Allow(id peer.ID, name string) (allowed bool, report bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.now()
+	if now.Sub(r.pruned) > rateLimiterPrunePeriod {
+		r.prune(now)
+	}
+
+	limit := r.limitOf(name)
+	key := bucketKey{id, name}
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		r.buckets[key] = bucket
+	}
+
+	if bucket.take(limit, now) {
+		return true, false
+	}
+	r.violations[id]++
+	if r.violations[id] < r.maxViolations {
+		return false, false
+	}
+	delete(r.violations, id)
+	return false, true
+}
+
+func (r *RateLimiter) limitOf(name string) RateLimit {
+	if limit, ok := r.protoLimits[name]; ok {
+		return limit
+	}
+	return r.limit
+}
+
+//acquire reserves handler slot, returns false if all of them are busy
+func (r *RateLimiter) acquire() bool {
+	select {
+	case r.handlers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *RateLimiter) release() {
+	<-r.handlers
+}
+
+//openStream registers persistent stream of the peer, returns false if peer has too many of them open already
+func (r *RateLimiter) openStream(id peer.ID, name string) bool {
+	r.Lock()
+	defer r.Unlock()
+	key := bucketKey{id, name}
+	if r.streams[key] >= r.maxStreams {
+		return false
+	}
+	r.streams[key]++
+	return true
+}
+
+func (r *RateLimiter) closeStream(id peer.ID, name string) {
+	r.Lock()
+	defer r.Unlock()
+	key := bucketKey{id, name}
+	r.streams[key]--
+	if r.streams[key] <= 0 {
+		delete(r.streams, key)
+	}
+}
+
+//prune forgets buckets refilled up to burst, they are no different from the new ones
+func (r *RateLimiter) prune(now time.Time) {
+	active := make(map[peer.ID]bool)
+	for key, bucket := range r.buckets {
+		limit := r.limitOf(key.proto)
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(r.buckets, key)
+			continue
+		}
+		active[key.id] = true
+	}
+	for id := range r.violations {
+		if !active[id] {
+			delete(r.violations, id)
+		}
+	}
+	r.pruned = now
+}
+
+func newRateLimiterFromConfig() *RateLimiter {
+	limit := RateLimit{viper.GetFloat64("p2p.rateLimit.rate"), viper.GetInt("p2p.rateLimit.burst")}
+	protoLimits := make(map[string]RateLimit)
+	err := viper.UnmarshalKey("p2p.rateLimit.protocols", &protoLimits)
+	if err != nil {
+		log.Warningf("Failed to read per protocol rate limits, using defaults: %s\n", err)
+	}
+	return NewRateLimiter(limit, protoLimits, viper.GetInt("p2p.rateLimit.maxHandlers"), viper.GetInt("p2p.rateLimit.maxViolations"),
+		viper.GetInt("p2p.rateLimit.maxStreams"))
+}
@@ -0,0 +1,89 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Burst: 2}, map[string]RateLimit{"transaction": {Rate: 10, Burst: 5}}, 1, 3, 1)
+	limiter.now = func() time.Time { return now }
+	id, other := peer.ID("peer"), peer.ID("other")
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow(id, DiscoverProto); !allowed {
+			t.Fatalf("stream %d within burst rejected", i)
+		}
+	}
+	if allowed, _ := limiter.Allow(id, DiscoverProto); allowed {
+		t.Fatal("stream over burst allowed")
+	}
+	if allowed, _ := limiter.Allow(other, DiscoverProto); !allowed {
+		t.Fatal("stream of another peer rejected")
+	}
+	for i := 0; i < 5; i++ {
+		if allowed, _ := limiter.Allow(id, TransactionProto); !allowed {
+			t.Fatalf("stream %d within protocol burst rejected", i)
+		}
+	}
+
+	now = now.Add(time.Second)
+	if allowed, _ := limiter.Allow(id, DiscoverProto); !allowed {
+		t.Fatal("stream rejected after bucket refill")
+	}
+
+	_, report := limiter.Allow(id, DiscoverProto)
+	if report {
+		t.Fatal("peer reported before max violations")
+	}
+	_, report = limiter.Allow(id, DiscoverProto)
+	if !report {
+		t.Fatal("peer not reported after max violations")
+	}
+
+	if !limiter.acquire() {
+		t.Fatal("free handler slot not acquired")
+	}
+	if limiter.acquire() {
+		t.Fatal("handler slot acquired over the limit")
+	}
+	limiter.release()
+	if !limiter.acquire() {
+		t.Fatal("released handler slot not acquired")
+	}
+
+	if !limiter.openStream(id, TransactionProto) {
+		t.Fatal("first persistent stream rejected")
+	}
+	if limiter.openStream(id, TransactionProto) {
+		t.Fatal("persistent stream over the limit opened")
+	}
+	if !limiter.openStream(other, TransactionProto) || !limiter.openStream(id, VoteAnnounceProto) {
+		t.Fatal("persistent stream of another peer or protocol rejected")
+	}
+	limiter.closeStream(id, TransactionProto)
+	if !limiter.openStream(id, TransactionProto) {
+		t.Fatal("persistent stream rejected after previous one closed")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, nil, 1, 10, 1)
+	limiter.now = func() time.Time { return now }
+	id := peer.ID("peer")
+	limiter.Allow(id, DiscoverProto)
+	limiter.Allow(id, DiscoverProto)
+
+	now = now.Add(2 * rateLimiterPrunePeriod)
+	limiter.Allow(peer.ID("other"), DiscoverProto)
+	if _, ok := limiter.buckets[bucketKey{id, DiscoverProto}]; ok {
+		t.Fatal("refilled bucket not pruned")
+	}
+	if limiter.violations[id] != 0 {
+		t.Fatal("violations of calmed down peer not forgotten")
+	}
+}
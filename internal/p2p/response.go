@@ -0,0 +1,92 @@
+package p2p
+
+import "fmt"
+
+//StatusCode tells requester how its request or announcement was handled
+type StatusCode int
+
+const (
+	StatusOK StatusCode = iota
+	StatusNotFound
+	StatusRejected
+	StatusRateLimited
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusNotFound:
+		return "not found"
+	case StatusRejected:
+		return "rejected"
+	case StatusRateLimited:
+		return "rate limited"
+	}
+	return fmt.Sprintf("unknown status %d", int(c))
+}
+
+//Response is an envelope sent back for every request and announcement,
+//in case of StatusOK response body, if protocol has one, follows it in the stream
+type Response struct {
+	Code   StatusCode
+	Reason string
+}
+
+//ResponseError is returned to requester when remote peer responded with status other than StatusOK
+type ResponseError struct {
+	Code   StatusCode
+	Reason string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Reason == "" {
+		return e.Code.String()
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+//IsNotFound tells whether error is remote peer not-found response
+func IsNotFound(err error) bool {
+	respErr, ok := err.(*ResponseError)
+	return ok && respErr.Code == StatusNotFound
+}
+
+//isRejection tells whether message was rejected by remote peer, while the stream is still usable
+func isRejection(err error) bool {
+	respErr, ok := err.(*ResponseError)
+	return ok && respErr.Code == StatusRejected
+}
+
+//respond sends status of handled message, rejection reason is taken from err
+func respond(ws *WrappedStream, err error) error {
+	if err != nil {
+		return sendResponse(ws, StatusRejected, err.Error())
+	}
+	return sendResponse(ws, StatusOK, "")
+}
+
+//sendResponse sends response envelope, nothing is sent over legacy protocol versions
+func sendResponse(ws *WrappedStream, code StatusCode, reason string) error {
+	if !hasEnvelope(ws.Protocol()) {
+		return nil
+	}
+	return sendMessage(Response{code, reason}, ws)
+}
+
+//receiveResponse reads response envelope, non-OK status is returned as *ResponseError.
+//Legacy protocol versions have no envelope, so response is always OK.
+func receiveResponse(ws *WrappedStream) (err error) {
+	if !hasEnvelope(ws.Protocol()) {
+		return
+	}
+	var resp Response
+	err = receiveMessage(&resp, ws)
+	if err != nil {
+		return fmt.Errorf("failed to receive response: %s", err)
+	}
+	if resp.Code != StatusOK {
+		return &ResponseError{resp.Code, resp.Reason}
+	}
+	return
+}
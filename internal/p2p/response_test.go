@@ -0,0 +1,22 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResponseError(t *testing.T) {
+	notFound := &ResponseError{StatusNotFound, "abc"}
+	rejected := &ResponseError{StatusRejected, "invalid signature: bad key"}
+	limited := &ResponseError{Code: StatusRateLimited}
+
+	if !IsNotFound(notFound) || IsNotFound(rejected) || IsNotFound(fmt.Errorf("not found")) {
+		t.Error("not-found response misdetected")
+	}
+	if !isRejection(rejected) || isRejection(limited) || isRejection(fmt.Errorf("rejected")) {
+		t.Error("rejection misdetected")
+	}
+	if rejected.Error() != "rejected: invalid signature: bad key" || limited.Error() != "rate limited" {
+		t.Errorf("unexpected error messages: %q, %q", rejected, limited)
+	}
+}
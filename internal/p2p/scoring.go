@@ -0,0 +1,264 @@
+package p2p
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+const bansFileName = "bans.json"
+
+//Misbehavior is a kind of peer offence lowering its score
+type Misbehavior int
+
+const (
+	InvalidSignature Misbehavior = iota
+	InvalidBlock
+	WrongSlot
+	MalformedMessage
+	Spam
+	RateLimitViolation
+	Untimely //message out of its time window, clocks skew routinely, so that it is not penalized
+)
+
+var penalties = map[Misbehavior]int{
+	InvalidSignature:   50,
+	InvalidBlock:       50,
+	WrongSlot:          20,
+	MalformedMessage:   10,
+	Spam:               5,
+	RateLimitViolation: 10,
+	Untimely:           0,
+}
+
+func (m Misbehavior) String() string {
+	switch m {
+	case InvalidSignature:
+		return "invalid signature"
+	case InvalidBlock:
+		return "invalid block"
+	case WrongSlot:
+		return "wrong slot"
+	case MalformedMessage:
+		return "malformed message"
+	case Spam:
+		return "spam"
+	case RateLimitViolation:
+		return "rate limit violation"
+	case Untimely:
+		return "untimely"
+	}
+	return "unknown misbehavior"
+}
+
+//PeerScores keeps score of every peer that misbehaved, peers with score below threshold get banned for banPeriod.
+//Score recovers by a point every recovery period, so that occasional offences of honest peers don't add up to a ban.
+//Bans are stored in data directory, so that they survive restarts.
+type PeerScores struct {
+	scores    map[peer.ID]int
+	updated   map[peer.ID]time.Time //when score recovered last time
+	bans      map[peer.ID]time.Time
+	threshold int
+	banPeriod time.Duration
+	recovery  time.Duration //0 - score never recovers
+	path      string
+	sync.Mutex
+}
+
+func NewPeerScores(dataDir string, threshold int, banPeriod time.Duration, recovery time.Duration) *PeerScores {
+	s := &PeerScores{
+		scores:    make(map[peer.ID]int),
+		updated:   make(map[peer.ID]time.Time),
+		bans:      make(map[peer.ID]time.Time),
+		threshold: threshold,
+		banPeriod: banPeriod,
+		recovery:  recovery,
+		path:      filepath.Join(dataDir, bansFileName),
+	}
+	err := s.load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Warningf("Failed to load bans from %s: %s\n", s.path, err)
+	}
+	return s
+}
+
+//Penalize lowers peer score according to misbehavior, returns true if peer got banned
+func (s *PeerScores) Penalize(id peer.ID, m Misbehavior) (banned bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.recover(id, time.Now())
+	if _, ok := s.scores[id]; !ok {
+		s.updated[id] = time.Now()
+	}
+	s.scores[id] -= penalties[m]
+	if s.scores[id] > s.threshold {
+		return
+	}
+	s.ban(id, s.banPeriod)
+	return true
+}
+
+func (s *PeerScores) Score(id peer.ID) int {
+	s.Lock()
+	defer s.Unlock()
+	s.recover(id, time.Now())
+	return s.scores[id]
+}
+
+//recover adds a point to peer score for every recovery period passed since the last recovery, peer is forgotten
+//once its score gets back to 0. Called under lock.
+func (s *PeerScores) recover(id peer.ID, now time.Time) {
+	score, ok := s.scores[id]
+	if !ok || s.recovery <= 0 {
+		return
+	}
+	points := int(now.Sub(s.updated[id]) / s.recovery)
+	if points == 0 {
+		return
+	}
+	if score+points >= 0 {
+		delete(s.scores, id)
+		delete(s.updated, id)
+		return
+	}
+	s.scores[id] = score + points
+	s.updated[id] = s.updated[id].Add(time.Duration(points) * s.recovery)
+}
+
+//Ban bans peer for given period, default ban period is used if period is 0
+func (s *PeerScores) Ban(id peer.ID, period time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	if period == 0 {
+		period = s.banPeriod
+	}
+	s.ban(id, period)
+}
+
+func (s *PeerScores) ban(id peer.ID, period time.Duration) {
+	s.bans[id] = time.Now().Add(period)
+	delete(s.scores, id)
+	delete(s.updated, id)
+	s.save()
+}
+
+func (s *PeerScores) Unban(id peer.ID) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.bans, id)
+	delete(s.scores, id)
+	delete(s.updated, id)
+	s.save()
+}
+
+func (s *PeerScores) IsBanned(id peer.ID) bool {
+	s.Lock()
+	defer s.Unlock()
+	until, ok := s.bans[id]
+	if ok && time.Now().After(until) {
+		delete(s.bans, id)
+		s.save()
+		return false
+	}
+	return ok
+}
+
+//Bans returns copy of active bans with their expiration times
+func (s *PeerScores) Bans() map[peer.ID]time.Time {
+	s.Lock()
+	defer s.Unlock()
+	bans := make(map[peer.ID]time.Time, len(s.bans))
+	now := time.Now()
+	for id, until := range s.bans {
+		if now.Before(until) {
+			bans[id] = until
+		}
+	}
+	return bans
+}
+
+//Scores returns copy of scores of all peers that misbehaved
+func (s *PeerScores) Scores() map[peer.ID]int {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for id := range s.scores {
+		s.recover(id, now)
+	}
+	scores := make(map[peer.ID]int, len(s.scores))
+	for id, score := range s.scores {
+		scores[id] = score
+	}
+	return scores
+}
+
+func (s *PeerScores) load() (err error) {
+	bytes, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return
+	}
+	var stored map[string]time.Time
+	err = json.Unmarshal(bytes, &stored)
+	if err != nil {
+		return
+	}
+	for idStr, until := range stored {
+		id, decErr := peer.IDB58Decode(idStr)
+		if decErr != nil {
+			log.Warningf("Skipping stored ban of %s: %s\n", idStr, decErr)
+			continue
+		}
+		s.bans[id] = until
+	}
+	return
+}
+
+func (s *PeerScores) save() {
+	stored := make(map[string]time.Time, len(s.bans))
+	for id, until := range s.bans {
+		stored[id.Pretty()] = until
+	}
+	bytes, err := json.Marshal(stored)
+	if err == nil {
+		err = ioutil.WriteFile(s.path, bytes, 0644)
+	}
+	if err != nil {
+		log.Warningf("Failed to save bans to %s: %s\n", s.path, err)
+	}
+}
+
+//Penalize lowers peer score, disconnects and forgets peer if it got banned
+func (h *AkhHost) Penalize(id peer.ID, m Misbehavior) {
+	if id == h.ID() || penalties[m] == 0 {
+		return
+	}
+	log.Warningf("%s: peer %s penalized for %s\n", h.ID().Pretty(), id.Pretty(), m)
+	if h.Scores.Penalize(id, m) {
+		log.Warningf("%s: peer %s banned\n", h.ID().Pretty(), id.Pretty())
+		h.disconnect(id)
+	}
+}
+
+func (h *AkhHost) BanPeer(id peer.ID, period time.Duration) {
+	h.Scores.Ban(id, period)
+	h.disconnect(id)
+}
+
+func (h *AkhHost) UnbanPeer(id peer.ID) {
+	h.Scores.Unban(id)
+}
+
+func (h *AkhHost) disconnect(id peer.ID) {
+	err := h.Network().ClosePeer(id)
+	if err != nil {
+		log.Warningf("Failed to disconnect %s: %s\n", id.Pretty(), err)
+	}
+	h.Peerstore().ClearAddrs(id)
+	h.AddrBook.Remove(id)
+}
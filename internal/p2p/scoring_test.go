@@ -0,0 +1,93 @@
+package p2p
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+func TestPeerScores_Penalize(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "akhscores")
+	defer os.RemoveAll(dir)
+
+	scores := NewPeerScores(dir, -100, time.Hour, 0)
+	id := peer.ID("misbehaving")
+
+	if scores.Penalize(id, InvalidSignature) {
+		t.Fatal("banned after first offence")
+	}
+	if scores.Score(id) != -penalties[InvalidSignature] {
+		t.Fatalf("unexpected score: %d", scores.Score(id))
+	}
+	if !scores.Penalize(id, InvalidBlock) {
+		t.Fatal("not banned after reaching threshold")
+	}
+	if !scores.IsBanned(id) {
+		t.Fatal("ban not registered")
+	}
+
+	restored := NewPeerScores(dir, -100, time.Hour, 0)
+	if !restored.IsBanned(id) {
+		t.Fatal("ban not restored after restart")
+	}
+
+	restored.Unban(id)
+	if restored.IsBanned(id) || NewPeerScores(dir, -100, time.Hour, 0).IsBanned(id) {
+		t.Fatal("peer still banned after unban")
+	}
+}
+
+func TestPeerScores_BanExpiration(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "akhscores")
+	defer os.RemoveAll(dir)
+
+	scores := NewPeerScores(dir, -100, time.Hour, 0)
+	id := peer.ID("banned")
+
+	scores.Ban(id, 10*time.Millisecond)
+	if !scores.IsBanned(id) {
+		t.Fatal("ban not registered")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if scores.IsBanned(id) {
+		t.Fatal("ban not expired")
+	}
+	if len(scores.Bans()) != 0 {
+		t.Fatalf("expired ban listed: %v", scores.Bans())
+	}
+}
+
+func TestPeerScores_Recovery(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "akhscores")
+	defer os.RemoveAll(dir)
+
+	scores := NewPeerScores(dir, -100, time.Hour, 10*time.Millisecond)
+	id := peer.ID("offender")
+
+	scores.Penalize(id, Spam)
+	if scores.Score(id) > -penalties[Spam]+1 {
+		t.Fatalf("score recovered too early: %d", scores.Score(id))
+	}
+	time.Sleep(time.Duration(penalties[Spam]+1) * 10 * time.Millisecond)
+	if scores.Score(id) != 0 {
+		t.Errorf("score not recovered: %d", scores.Score(id))
+	}
+	if _, ok := scores.Scores()[id]; ok {
+		t.Error("recovered peer still listed")
+	}
+
+	//offences within recovery period add up to a ban, untimely messages don't count
+	slow := NewPeerScores(dir, -100, time.Hour, time.Hour)
+	for i := 0; i < 100/penalties[MalformedMessage]-1; i++ {
+		slow.Penalize(id, MalformedMessage)
+	}
+	if slow.Penalize(id, Untimely) {
+		t.Error("banned for untimely message")
+	}
+	if !slow.Penalize(id, MalformedMessage) {
+		t.Error("not banned after frequent offences")
+	}
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+//Status describes the node to its peers
+type Status struct {
+	ID                 string
+	NetworkFingerprint string //empty for public network
+	Protocols          []string
+	TimeStamp          int64 //local time when status was sent, peers measure clock offset with it
+}
+
+type GetStatusMessage struct {
+	Message
+}
+
+type StatusStreamHandler struct {
+	host *AkhHost
+}
+
+func (*StatusStreamHandler) protocol() string {
+	return StatusProto
+}
+
+func (srp *StatusStreamHandler) handle(ws *WrappedStream) {
+	getAnswer := func() interface{} {
+		return srp.host.Status()
+	}
+
+	err := answer(ws, &GetStatusMessage{}, getAnswer)
+	if err != nil {
+		log.Warningf("Error handling status stream: %s", err)
+	}
+}
+
+func (h *AkhHost) Status() Status {
+	return Status{
+		ID:                 h.ID().Pretty(),
+		NetworkFingerprint: h.NetworkFingerprint,
+		Protocols:          h.Mux().Protocols(),
+		TimeStamp:          blockchain.GetTimeStamp(),
+	}
+}
+
+func (h *AkhHost) GetStatus(peerID peer.ID) (status Status, err error) {
+	err = h.ask(peerID, GetStatusMessage{}, StatusProto, &status)
+	return
+}
@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("p2p.maxTimeOffset", 10*60*1000)
+	viper.SetDefault("p2p.timeOffsetWarning", 500)
+	viper.SetDefault("p2p.timeSamplePeriod", 10*60)
+}
+
+//minTimeSamples is number of peers clocks required to adjust the local one
+const minTimeSamples = 5
+
+//maxTimeSamples bounds number of peers clocks kept
+const maxTimeSamples = 200
+
+//TimeOffsets keeps offsets of peers clocks from the local one, network time is local time corrected by their median.
+//Only one peer of every subnet bucket (see AddrBucket) is sampled, so that single operator can't shift the median
+//by connecting many peers.
+type TimeOffsets struct {
+	samples map[string]timeSample //by bucket
+	offset  int64
+	max     int64 //peers clocks further off are ignored as broken or malicious
+	warning int64 //local clock further off the network time is reported
+	warned  bool
+	sync.Mutex
+}
+
+type timeSample struct {
+	id     peer.ID
+	offset int64
+}
+
+func NewTimeOffsets(max time.Duration, warning time.Duration) *TimeOffsets {
+	return &TimeOffsets{samples: make(map[string]timeSample), max: int64(max), warning: int64(warning)}
+}
+
+//Add registers offset of the peer clock, nanosec, the last one is kept for every peer.
+//Sample is ignored if other peer of the bucket is sampled already.
+func (o *TimeOffsets) Add(id peer.ID, bucket string, offset int64) {
+	o.Lock()
+	defer o.Unlock()
+	sample, ok := o.samples[bucket]
+	if ok && sample.id != id {
+		return
+	}
+	if !ok && len(o.samples) >= maxTimeSamples {
+		return
+	}
+	o.samples[bucket] = timeSample{id, offset}
+	o.update()
+}
+
+//Remove drops sample of the disconnected peer, freeing its bucket for other peers
+func (o *TimeOffsets) Remove(id peer.ID) {
+	o.Lock()
+	defer o.Unlock()
+	for bucket, sample := range o.samples {
+		if sample.id == id {
+			delete(o.samples, bucket)
+			o.update()
+			return
+		}
+	}
+}
+
+//update recalculates median offset, must be called under lock
+func (o *TimeOffsets) update() {
+	accepted := make([]int64, 0, len(o.samples))
+	for _, sample := range o.samples {
+		if abs(sample.offset) <= o.max {
+			accepted = append(accepted, sample.offset)
+		}
+	}
+	if len(o.samples) >= minTimeSamples && len(accepted) < len(o.samples)/2 {
+		log.Warningf("Clocks of most peers are more than %v off the local one, check system time\n", time.Duration(o.max))
+	}
+	if len(accepted) < minTimeSamples {
+		o.offset = 0
+		return
+	}
+
+	sort.Slice(accepted, func(i, j int) bool { return accepted[i] < accepted[j] })
+	middle := len(accepted) / 2
+	o.offset = accepted[middle]
+	if len(accepted)%2 == 0 {
+		o.offset = (accepted[middle-1] + accepted[middle]) / 2
+	}
+
+	if abs(o.offset) > o.warning && !o.warned {
+		log.Warningf("Local clock is %v off the network time, check system time\n", time.Duration(o.offset))
+	}
+	o.warned = abs(o.offset) > o.warning
+}
+
+//Offset returns median offset of peers clocks, nanosec, 0 until enough peers are sampled
+func (o *TimeOffsets) Offset() int64 {
+	o.Lock()
+	defer o.Unlock()
+	return o.offset
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+//sampleTime measures offset of the peer clock from the status it reports, half of round trip is assumed to be
+//taken by each direction
+func (h *AkhHost) sampleTime(id peer.ID) (err error) {
+	sent := blockchain.GetTimeStamp()
+	status, err := h.GetStatus(id)
+	if err != nil {
+		return
+	}
+	received := blockchain.GetTimeStamp()
+	if status.TimeStamp == 0 {
+		return fmt.Errorf("%s didn't report its time", id.Pretty())
+	}
+	bucket, ok := h.ConnManager.Bucket(id)
+	if !ok {
+		return fmt.Errorf("%s is not connected", id.Pretty())
+	}
+	h.TimeOffsets.Add(id, bucket, status.TimeStamp-(sent+received)/2)
+	return
+}
+
+//startTimeSampling re-samples clocks of connected peers every period until host is closed, so that the network
+//time follows drift of the local clock
+func (h *AkhHost) startTimeSampling(period time.Duration) {
+	ticker := time.NewTicker(period)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, id := range h.ConnManager.Peers() {
+					err := h.sampleTime(id)
+					if err != nil {
+						log.Debugf("%s: failed to sample time of %s: %s", h.ID().Pretty(), id.Pretty(), err)
+					}
+				}
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
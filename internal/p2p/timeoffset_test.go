@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+func TestTimeOffsets_Median(t *testing.T) {
+	offsets := NewTimeOffsets(time.Minute, time.Second)
+	second := int64(time.Second)
+
+	for i, offset := range []int64{2 * second, -second, 3 * second} {
+		offsets.Add(peer.ID(fmt.Sprintf("peer%d", i)), fmt.Sprintf("10.%d.0.0/16", i), offset)
+	}
+	if offsets.Offset() != 0 {
+		t.Fatalf("local time adjusted by %d before enough peers sampled", offsets.Offset())
+	}
+
+	//broken clocks don't count
+	offsets.Add("broken", "10.100.0.0/16", int64(time.Hour))
+	offsets.Add("malicious", "10.101.0.0/16", -int64(time.Hour))
+	offsets.Add("peer3", "10.3.0.0/16", 4*second)
+	if offsets.Offset() != 0 {
+		t.Fatalf("local time adjusted by %d before enough peers sampled", offsets.Offset())
+	}
+
+	offsets.Add("peer4", "10.4.0.0/16", 5*second)
+	if offsets.Offset() != 3*second {
+		t.Errorf("offset = %d, expected median %d", offsets.Offset(), 3*second)
+	}
+
+	offsets.Add("peer5", "10.5.0.0/16", 0)
+	if offsets.Offset() != 5*second/2 {
+		t.Errorf("offset = %d, expected median %d", offsets.Offset(), 5*second/2)
+	}
+
+	//the last sample of the peer replaces previous one
+	offsets.Add("peer5", "10.5.0.0/16", 6*second)
+	if offsets.Offset() != 7*second/2 {
+		t.Errorf("offset = %d, expected median %d", offsets.Offset(), 7*second/2)
+	}
+
+	//sample of disconnected peer is dropped
+	offsets.Remove("peer5")
+	if offsets.Offset() != 3*second {
+		t.Errorf("offset = %d, expected median %d", offsets.Offset(), 3*second)
+	}
+}
+
+func TestTimeOffsets_Bucket(t *testing.T) {
+	offsets := NewTimeOffsets(time.Minute, time.Second)
+	second := int64(time.Second)
+
+	for i := 0; i < minTimeSamples; i++ {
+		offsets.Add(peer.ID(fmt.Sprintf("peer%d", i)), fmt.Sprintf("10.%d.0.0/16", i), 0)
+	}
+
+	//peers of the same subnet don't outweigh others
+	for i := 0; i < minTimeSamples; i++ {
+		offsets.Add(peer.ID(fmt.Sprintf("sybil%d", i)), "192.168.0.0/16", 30*second)
+	}
+	if offsets.Offset() != 0 {
+		t.Errorf("offset = %d, single subnet shifted the median", offsets.Offset())
+	}
+
+	//bucket is freed when its peer disconnects
+	offsets.Remove("sybil0")
+	offsets.Add("sybil1", "192.168.0.0/16", second)
+	offsets.Remove("peer0")
+	offsets.Remove("peer1")
+	offsets.Remove("peer2")
+	offsets.Add("peer5", "10.5.0.0/16", second)
+	offsets.Add("peer6", "10.6.0.0/16", second)
+	if offsets.Offset() != second {
+		t.Errorf("offset = %d, expected median %d", offsets.Offset(), second)
+	}
+}
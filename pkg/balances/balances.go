@@ -14,9 +14,18 @@ type Balances struct {
 		string
 		uint
 	}
+	revertChan       chan blockchain.Transaction
+	revertRewardChan chan struct {
+		string
+		uint
+	}
+	snapshotChan chan chan map[string]uint64
+	restoreChan  chan map[string]uint64
+	listeners    []func(id string, balance uint64)
 }
 
-func NewBalances() *Balances {
+//NewBalances creates balances, listeners are notified about every balance change, e.g. to follow voters stake
+func NewBalances(listeners ...func(id string, balance uint64)) *Balances {
 	m := make(map[string]uint64, 100) //magic constant
 	put := make(chan blockchain.Transaction)
 	getChan := make(chan string)
@@ -25,15 +34,36 @@ func NewBalances() *Balances {
 		string
 		uint
 	})
-	b := &Balances{&m, put, getChan, responseChan, rewardChan}
+	revertRewardChan := make(chan struct {
+		string
+		uint
+	})
+	b := &Balances{&m, put, getChan, responseChan, rewardChan, make(chan blockchain.Transaction), revertRewardChan,
+		make(chan chan map[string]uint64), make(chan map[string]uint64), listeners}
 	go func(b *Balances) {
 		for {
 			select {
 			case t := <-b.putChan:
 				(*b.m)[t.GetSigner()] -= t.Amount
 				(*b.m)[t.Recipient] += t.Amount
+				b.notify(t.GetSigner())
+				b.notify(t.Recipient)
 			case r := <-b.rewardChan:
 				(*b.m)[r.string] += uint64(r.uint)
+				b.notify(r.string)
+			case t := <-b.revertChan:
+				(*b.m)[t.Recipient] -= t.Amount
+				(*b.m)[t.GetSigner()] += t.Amount
+				b.notify(t.Recipient)
+				b.notify(t.GetSigner())
+			case r := <-b.revertRewardChan:
+				(*b.m)[r.string] -= uint64(r.uint)
+				b.notify(r.string)
+			case response := <-b.snapshotChan:
+				response <- copyBalances(*b.m)
+			case restored := <-b.restoreChan:
+				//listeners are expected to restore their state from the same point themselves
+				*b.m = copyBalances(restored)
 			case a := <-b.getChan:
 				responseChan <- (*b.m)[a]
 			}
@@ -42,6 +72,20 @@ func NewBalances() *Balances {
 	return b
 }
 
+func copyBalances(m map[string]uint64) map[string]uint64 {
+	result := make(map[string]uint64, len(m))
+	for id, balance := range m {
+		result[id] = balance
+	}
+	return result
+}
+
+func (b *Balances) notify(id string) {
+	for _, listener := range b.listeners {
+		listener(id, (*b.m)[id])
+	}
+}
+
 func (b *Balances) Submit(t blockchain.Transaction) (err error) {
 	b.putChan <- t
 	return
@@ -54,6 +98,36 @@ func (b *Balances) SubmitReward(receiver string, amount uint) {
 	}{receiver, amount}
 }
 
+//Revert cancels transaction submitted before, e.g. when its block is disconnected from the chain
+func (b *Balances) Revert(t blockchain.Transaction) {
+	b.revertChan <- t
+}
+
+func (b *Balances) RevertReward(receiver string, amount uint) {
+	b.revertRewardChan <- struct {
+		string
+		uint
+	}{receiver, amount}
+}
+
+//Snapshot returns copy of all balances
+func (b *Balances) Snapshot() map[string]uint64 {
+	response := make(chan map[string]uint64)
+	b.snapshotChan <- response
+	return <-response
+}
+
+//Restore replaces all balances with the snapshot ones, listeners are not notified
+func (b *Balances) Restore(snapshot map[string]uint64) {
+	b.restoreChan <- snapshot
+	b.Sync()
+}
+
+//Sync waits until all submitted changes are applied and listeners are notified about them
+func (b *Balances) Sync() {
+	b.Get("")
+}
+
 func (b *Balances) Get(peerID string) uint64 {
 	b.getChan <- peerID
 	return <-b.responseChan
@@ -45,3 +45,43 @@ func TestBalances_CollectValidTxns(t *testing.T) {
 		t.Fatalf("Invalid transactions not filtered")
 	}
 }
+
+func TestBalances_Listeners(t *testing.T) {
+	changes := make(map[string]uint64)
+	b := NewBalances(func(id string, balance uint64) {
+		changes[id] = balance
+	})
+
+	b.SubmitReward("bank", 100)
+	b.Submit(blockchain.Transaction{Unit: blockchain.Unit{Signer: "bank"}, Recipient: "me", Amount: 42})
+	b.Get("bank") //wait for submitted changes to be processed
+
+	if changes["bank"] != 58 || changes["me"] != 42 {
+		t.Fatalf("balance changes not followed: %v", changes)
+	}
+}
+
+func TestBalances_Revert(t *testing.T) {
+	b := NewBalances()
+	b.SubmitReward("bank", 100)
+	snapshot := b.Snapshot()
+
+	transaction := blockchain.Transaction{Unit: blockchain.Unit{Signer: "bank"}, Recipient: "me", Amount: 42}
+	b.Submit(transaction)
+	b.SubmitReward("me", 1)
+	b.RevertReward("me", 1)
+	b.Revert(transaction)
+	if b.Get("bank") != 100 || b.Get("me") != 0 {
+		t.Fatalf("transaction not reverted: bank = %d, me = %d", b.Get("bank"), b.Get("me"))
+	}
+
+	b.Submit(transaction)
+	b.Restore(snapshot)
+	if b.Get("bank") != 100 || b.Get("me") != 0 {
+		t.Fatalf("snapshot not restored: bank = %d, me = %d", b.Get("bank"), b.Get("me"))
+	}
+	snapshot["bank"] = 0
+	if b.Get("bank") != 100 {
+		t.Fatal("snapshot shares state with balances")
+	}
+}
@@ -17,8 +17,12 @@ type BlockData struct {
 	Transactions Transactions
 	Votes        Votes
 	Reward       uint
+	Evidence     []Equivocation `json:",omitempty"`
 }
 
+//blockDomain starts block corpus, so that signature of a vote or transaction never passes for signature of a block
+var blockDomain = []byte("akhcoin/block\x00")
+
 type Block struct {
 	BlockData
 	Parent *Block
@@ -31,7 +35,8 @@ type Votes []Vote
 
 func (block *BlockData) GetCorpus() *bytes.Buffer {
 	// Gather corpus to Sign.
-	corpus := block.Unit.GetCorpus()
+	corpus := bytes.NewBuffer(append([]byte(nil), blockDomain...))
+	corpus.Write(block.Unit.GetCorpus().Bytes())
 	corpus.Write([]byte(block.ParentHash))
 	for _, t := range block.Transactions {
 		corpus.Write(t.Sign)
@@ -40,6 +45,10 @@ func (block *BlockData) GetCorpus() *bytes.Buffer {
 		corpus.Write(v.Sign)
 	}
 	corpus.Write(getBytes(int64(block.Reward)))
+	for _, e := range block.Evidence {
+		corpus.Write(e.First.Sign)
+		corpus.Write(e.Second.Sign)
+	}
 
 	return corpus
 }
@@ -59,31 +68,37 @@ func CreateGenesis() *Block {
 		TimeStamp: time.Date(2018, 02, 13, 06, 00, 00, 00, time.UTC).UnixNano()}}}
 }
 
-func NewBlock(privateKey crypto.PrivKey, parent *Block, transactions []Transaction, votes []Vote) *Block {
+func NewBlock(privateKey crypto.PrivKey, parent *Block, transactions []Transaction, votes []Vote, evidence ...Equivocation) *Block {
+	return NewBlockAt(privateKey, parent, GetTimeStamp(), transactions, votes, evidence...)
+}
+
+//NewBlockAt creates block with the timestamp given, e.g. by clock the producer follows
+func NewBlockAt(privateKey crypto.PrivKey, parent *Block, timeStamp int64, transactions []Transaction, votes []Vote, evidence ...Equivocation) *Block {
 	block := &Block{
 		BlockData{
 			Transactions: transactions,
 			Votes:        votes,
 			ParentHash:   parent.Hash,
+			Evidence:     evidence,
 		},
 		parent,
 		nil,
 	}
-	block.Hash = Hash(block.GetCorpus().Bytes())
 	parent.Next = block
-	block.TimeStamp = GetTimeStamp()
+	block.TimeStamp = timeStamp
 	block.Reward = uint(viper.GetInt("reward"))
 
 	//TODO error handling
 	id, _ := peer.IDFromPrivateKey(privateKey)
 	block.Signer = id.Pretty()
 	block.PublicKey, _ = privateKey.GetPublic().Bytes()
+	block.Hash = Hash(block.GetCorpus().Bytes())
 	block.Sign, _ = privateKey.Sign(block.GetCorpus().Bytes())
 
 	return block
 }
 
-//Block contents verification. Checks basic cryptography and transactions timing
+//Block contents verification. Checks basic cryptography and transactions timing, evidence is checked with VerifyEvidence
 func (block *BlockData) Verify(parent *BlockData) (valid bool, err error) {
 	if block.ParentHash != parent.Hash {
 		err = fmt.Errorf("block %s has %s ParentHash, %s required", block.Hash, block.ParentHash, parent.Hash)
@@ -96,6 +111,11 @@ func (block *BlockData) Verify(parent *BlockData) (valid bool, err error) {
 		return
 	}
 
+	if block.Hash != Hash(block.GetCorpus().Bytes()) {
+		err = fmt.Errorf("block %s hash doesn't match its contents", block.Hash)
+		return
+	}
+
 	valid, err = verify(block)
 	if !valid {
 		err = fmt.Errorf("invalid block: %s: %s", block.Hash, err)
@@ -130,3 +150,15 @@ func (block *BlockData) Verify(parent *BlockData) (valid bool, err error) {
 
 	return
 }
+
+//VerifyEvidence checks equivocation evidence the block carries against slots of given period, see SlotStart
+func (block *BlockData) VerifyEvidence(period int64, epsilon int64) (valid bool, err error) {
+	for _, e := range block.Evidence {
+		valid, err = e.Verify(period, epsilon)
+		if !valid {
+			err = fmt.Errorf("invalid equivocation evidence in block %s: %s", block.Hash, err)
+			return
+		}
+	}
+	return true, nil
+}
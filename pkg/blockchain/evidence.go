@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+//BlockHeader is signed corpus of the block, sufficient to prove who produced it and when without block contents
+type BlockHeader struct {
+	Hash      string
+	Signer    string
+	PublicKey []byte
+	Sign      []byte
+	Corpus    []byte
+}
+
+func (block *BlockData) Header() BlockHeader {
+	return BlockHeader{
+		Hash:      block.Hash,
+		Signer:    block.Signer,
+		PublicKey: block.PublicKey,
+		Sign:      block.Sign,
+		Corpus:    block.GetCorpus().Bytes(),
+	}
+}
+
+func (h *BlockHeader) GetSigner() string {
+	return h.Signer
+}
+
+func (h *BlockHeader) GetPublicKey() []byte {
+	return h.PublicKey
+}
+
+func (h *BlockHeader) GetCorpus() *bytes.Buffer {
+	return bytes.NewBuffer(append([]byte(nil), h.Corpus...))
+}
+
+func (h *BlockHeader) GetSign() []byte {
+	return h.Sign
+}
+
+//GetTimestamp returns block timestamp written in corpus, -1 if corpus is not the one of a block
+func (h *BlockHeader) GetTimestamp() int64 {
+	prefix := append(append([]byte(nil), blockDomain...), h.Signer...)
+	if !bytes.HasPrefix(h.Corpus, prefix) || len(h.Corpus) < len(prefix)+len(getBytes(0)) {
+		return -1
+	}
+	ts, n := binary.Varint(h.Corpus[len(prefix) : len(prefix)+len(getBytes(0))])
+	if n <= 0 {
+		return -1
+	}
+	return ts
+}
+
+//Verify checks header is the one of a block signed by its signer, with hash derived from the corpus
+func (h *BlockHeader) Verify() (valid bool, err error) {
+	if h.GetTimestamp() < 0 {
+		return false, fmt.Errorf("malformed header of %s", h.Signer)
+	}
+	if h.Hash != Hash(h.Corpus) {
+		return false, fmt.Errorf("header hash %s of %s doesn't match its corpus", h.Hash, h.Signer)
+	}
+	return verify(h)
+}
+
+//Equivocation is a proof of producer signing two different blocks for the same slot
+type Equivocation struct {
+	First  BlockHeader
+	Second BlockHeader
+}
+
+//NewEquivocation orders headers canonically, so that evidence of the same pair built by different nodes is identical
+func NewEquivocation(a BlockHeader, b BlockHeader) *Equivocation {
+	if bytes.Compare(a.Sign, b.Sign) > 0 {
+		a, b = b, a
+	}
+	return &Equivocation{a, b}
+}
+
+func (e *Equivocation) Offender() string {
+	return e.First.Signer
+}
+
+func (e *Equivocation) Hash() string {
+	return Hash(append(append([]byte(nil), e.First.Sign...), e.Second.Sign...))
+}
+
+func (e *Equivocation) String() string {
+	return fmt.Sprintf("%s signed two blocks at %d", e.Offender(), e.First.GetTimestamp())
+}
+
+//Verify checks both headers are of blocks signed by the same producer for the same slot of given period and the blocks
+//differ, see SlotStart
+func (e *Equivocation) Verify(period int64, epsilon int64) (valid bool, err error) {
+	if e.First.Signer != e.Second.Signer {
+		return false, fmt.Errorf("headers signed by different producers: %s and %s", e.First.Signer, e.Second.Signer)
+	}
+	for _, h := range []BlockHeader{e.First, e.Second} {
+		valid, err = h.Verify()
+		if !valid {
+			return false, fmt.Errorf("invalid header of %s: %s", h.Signer, err)
+		}
+	}
+	if e.First.Hash == e.Second.Hash {
+		return false, fmt.Errorf("headers of %s are of the same block %s", e.First.Signer, e.First.Hash)
+	}
+	if period <= 0 {
+		return false, fmt.Errorf("slot period is not set")
+	}
+	first, err := SlotStart(e.First.GetTimestamp(), period, epsilon)
+	if err != nil {
+		return false, fmt.Errorf("header of %s out of slot: %s", e.First.Signer, err)
+	}
+	second, err := SlotStart(e.Second.GetTimestamp(), period, epsilon)
+	if err != nil {
+		return false, fmt.Errorf("header of %s out of slot: %s", e.Second.Signer, err)
+	}
+	if first != second {
+		return false, fmt.Errorf("headers of %s are not for the same slot", e.First.Signer)
+	}
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-crypto"
+	"github.com/spf13/viper"
+)
+
+func ExampleEquivocation() {
+	viper.Set("reward", 50)
+	period, epsilon := int64(10e9), int64(1e6)
+
+	priv, _, _ := NewKeys()
+	other, _, _ := NewKeys()
+	parent := CreateGenesis()
+	stamp := func(block *Block, timeStamp int64, key crypto.PrivKey) *Block {
+		block.TimeStamp = timeStamp
+		block.Hash = Hash(block.GetCorpus().Bytes())
+		block.Sign, _ = key.Sign(block.GetCorpus().Bytes())
+		return block
+	}
+	slotStart := GetTimeStamp() - GetTimeStamp()%period
+	first := stamp(NewBlock(priv, parent, nil, nil), slotStart, priv)
+	second := stamp(NewBlock(priv, parent, []Transaction{}, []Vote{*NewVote(other, "candidate")}), slotStart, priv)
+
+	e := NewEquivocation(first.Header(), second.Header())
+	valid, _ := e.Verify(period, epsilon)
+	fmt.Println(valid, e.Hash() == NewEquivocation(second.Header(), first.Header()).Hash())
+
+	_, err := NewEquivocation(first.Header(), first.Header()).Verify(period, epsilon)
+	fmt.Println(err != nil)
+
+	forged := second.Header()
+	forged.Corpus = append(forged.Corpus, 0)
+	_, err = NewEquivocation(first.Header(), forged).Verify(period, epsilon)
+	fmt.Println(err != nil)
+
+	//vote signature doesn't pass for block one
+	vote := NewVote(priv, "candidate")
+	vote.TimeStamp = first.TimeStamp
+	vote.Sign, _ = priv.Sign(vote.GetCorpus().Bytes())
+	voteCorpus := vote.GetCorpus().Bytes()
+	fromVote := BlockHeader{Hash(voteCorpus), vote.Signer, vote.PublicKey, vote.Sign, voteCorpus}
+	_, err = NewEquivocation(first.Header(), fromVote).Verify(period, epsilon)
+	fmt.Println(err != nil)
+
+	//blocks straddling period boundary within epsilon are of the same slot, blocks of adjacent slots are not
+	early := stamp(NewBlock(priv, parent, nil, nil), slotStart-epsilon/2, priv)
+	valid, _ = NewEquivocation(early.Header(), second.Header()).Verify(period, epsilon)
+	previous := stamp(NewBlock(priv, parent, nil, nil), slotStart-period+epsilon/2, priv)
+	_, err = NewEquivocation(early.Header(), previous.Header()).Verify(period, epsilon)
+	fmt.Println(valid, err != nil)
+
+	third := NewBlock(other, parent, nil, nil)
+	verified, _ := third.VerifyEvidence(period, epsilon)
+	withEvidence := NewBlock(other, parent, nil, nil, *e)
+	verifiedWithEvidence, _ := withEvidence.Verify(&parent.BlockData)
+	evidenceVerified, _ := withEvidence.VerifyEvidence(period, epsilon)
+	fmt.Println(verified, verifiedWithEvidence && evidenceVerified, withEvidence.Hash != third.Hash)
+
+	// Output:
+	// true true
+	// true
+	// true
+	// true
+	// true true
+	// true true true
+}
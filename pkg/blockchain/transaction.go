@@ -38,11 +38,16 @@ func (t *Transaction) Verify() (result bool, err error) {
 }
 
 func Pay(private crypto.PrivKey, recipient peer.ID, amount uint64) *Transaction {
+	return PayAt(private, recipient, amount, GetTimeStamp())
+}
+
+//PayAt creates transaction with the timestamp given, e.g. by clock the node follows
+func PayAt(private crypto.PrivKey, recipient peer.ID, amount uint64, timeStamp int64) *Transaction {
 
 	sender, _ := peer.IDFromPrivateKey(private)
 	public, _ := private.GetPublic().Bytes()
 
-	t := Transaction{Unit: Unit{Signer: sender.Pretty(), PublicKey: public, TimeStamp: GetTimeStamp()}, Recipient: recipient.Pretty(), Amount: amount}
+	t := Transaction{Unit: Unit{Signer: sender.Pretty(), PublicKey: public, TimeStamp: timeStamp}, Recipient: recipient.Pretty(), Amount: amount}
 	sign, _ := private.Sign(t.GetCorpus().Bytes())
 	t.Sign = sign
 
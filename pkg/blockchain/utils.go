@@ -18,11 +18,26 @@ func GetTimeStamp() int64 {
 	return CurrentTime().UnixNano()
 }
 
-//TODO implement network time adjustment
+//CurrentTime returns local system time, node follows network adjusted one, see p2p.TimeOffsets
 func CurrentTime() time.Time {
 	return time.Now().UTC()
 }
 
+//SlotStart returns start of the slot block with the timestamp belongs to, error is returned if timestamp is off the slot
+//start by epsilon or more. Slot of a block is defined by it only, for validation, equivocation and slashing protection.
+func SlotStart(timeStamp int64, period int64, epsilon int64) (slotStart int64, err error) {
+	slotStart = timeStamp + epsilon
+	slotStart -= slotStart % period
+	diff := slotStart - timeStamp
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff >= epsilon {
+		err = fmt.Errorf("incorrect timestamp: %d. Required: %d ± %v", timeStamp, slotStart, epsilon)
+	}
+	return
+}
+
 func HashStr(str string) string {
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(str)))
 }
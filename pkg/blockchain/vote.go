@@ -8,13 +8,21 @@ import (
 	"github.com/libp2p/go-libp2p-peer"
 )
 
+//Vote stays in effect until voter changes it or withdraws it with the Vote having Withdraw set
 type Vote struct {
 	Unit
 	Candidate string
+	Withdraw  bool `json:",omitempty"`
 }
 
+//GetCorpus signs Withdraw flag as a byte ahead of candidate, so that vote and withdrawal never share corpus
 func (v *Vote) GetCorpus() *bytes.Buffer {
 	corpus := v.Unit.GetCorpus()
+	if v.Withdraw {
+		corpus.WriteByte(1)
+	} else {
+		corpus.WriteByte(0)
+	}
 	corpus.Write([]byte(v.Candidate))
 	return corpus
 }
@@ -28,15 +36,37 @@ func (v *Vote) Verify() (result bool, err error) {
 }
 
 func (v *Vote) String() string {
+	if v.Withdraw {
+		return fmt.Sprintf("%s withdrew vote for %s", v.Signer, v.Candidate)
+	}
 	return fmt.Sprintf("%s voted for %s", v.Signer, v.Candidate)
 }
 
 func NewVote(private crypto.PrivKey, candidate peer.ID) *Vote {
+	return newVote(private, candidate, false, GetTimeStamp())
+}
+
+//NewVoteAt creates vote with the timestamp given, e.g. by clock the node follows
+func NewVoteAt(private crypto.PrivKey, candidate peer.ID, timeStamp int64) *Vote {
+	return newVote(private, candidate, false, timeStamp)
+}
+
+//NewWithdrawal creates vote cancelling previous vote of the same signer for the candidate
+func NewWithdrawal(private crypto.PrivKey, candidate peer.ID) *Vote {
+	return newVote(private, candidate, true, GetTimeStamp())
+}
+
+//NewWithdrawalAt creates withdrawal with the timestamp given, see NewWithdrawal
+func NewWithdrawalAt(private crypto.PrivKey, candidate peer.ID, timeStamp int64) *Vote {
+	return newVote(private, candidate, true, timeStamp)
+}
+
+func newVote(private crypto.PrivKey, candidate peer.ID, withdraw bool, timeStamp int64) *Vote {
 
 	sender, _ := peer.IDFromPrivateKey(private)
 	public, _ := private.GetPublic().Bytes()
 
-	v := Vote{Unit: Unit{Signer: sender.Pretty(), PublicKey: public, TimeStamp: GetTimeStamp()}, Candidate: candidate.Pretty()}
+	v := Vote{Unit: Unit{Signer: sender.Pretty(), PublicKey: public, TimeStamp: timeStamp}, Candidate: candidate.Pretty(), Withdraw: withdraw}
 	sign, _ := private.Sign(v.GetCorpus().Bytes())
 	v.Sign = sign
 
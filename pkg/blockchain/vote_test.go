@@ -0,0 +1,39 @@
+package blockchain
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p-peer"
+)
+
+func ExampleVote() {
+	priv, _, _ := NewKeys()
+	v := NewVote(priv, peer.ID("some"))
+	verified, _ := v.Verify()
+	fmt.Println(verified)
+
+	//vote can't be turned into withdrawal
+	v.Withdraw = true
+	verified, _ = v.Verify()
+	fmt.Println(verified)
+
+	//nor withdrawal into vote for another candidate
+	w := NewWithdrawal(priv, peer.ID("some"))
+	forged := *w
+	forged.Withdraw = false
+	forged.Candidate = w.Candidate + "withdraw"
+	verified, _ = forged.Verify()
+	fmt.Println(verified)
+	// Output:
+	// true
+	// false
+	// false
+}
+
+func ExampleNewVoteAt() {
+	priv, _, _ := NewKeys()
+	v := NewVoteAt(priv, peer.ID("some"), 42)
+	verified, _ := v.Verify()
+	fmt.Println(v.GetTimestamp(), verified)
+	// Output:
+	// 42 true
+}
@@ -0,0 +1,96 @@
+package consensus
+
+import (
+	"fmt"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/spf13/viper"
+)
+
+//FixedAuthority is engine for local development and tests: configured authorities produce blocks round-robin,
+//there is no voting, so that engine has no state
+type FixedAuthority struct {
+	authorities  []string
+	genesisStart int64
+	period       int64
+	epsilon      int64
+}
+
+func NewFixedAuthority(authorities []string, genesisStart int64) *FixedAuthority {
+	log.Debugf("New fixed authority config: authorities = %v, p = %d", authorities, viper.GetInt64("poll.period"))
+	return &FixedAuthority{append([]string(nil), authorities...), genesisStart, viper.GetInt64("poll.period"),
+		viper.GetInt64("poll.epsilon")}
+}
+
+func (a *FixedAuthority) Period() int64 {
+	return a.period
+}
+
+func (a *FixedAuthority) Epsilon() int64 {
+	return a.epsilon
+}
+
+func (a *FixedAuthority) GetMaxElected() int {
+	return len(a.authorities)
+}
+
+func (a *FixedAuthority) GetCurrentSlotStart(timeStamp int64) int64 {
+	return timeStamp - timeStamp%a.period
+}
+
+func (a *FixedAuthority) GetSchedule(timeStamp int64) []string {
+	return append([]string(nil), a.authorities...)
+}
+
+//GetProducer returns authority of the slot, nobody produces before genesis
+func (a *FixedAuthority) GetProducer(timeStamp int64) string {
+	if a.period <= 0 || timeStamp < a.genesisStart {
+		return ""
+	}
+	return a.authorities[(timeStamp-a.genesisStart)/a.period%int64(len(a.authorities))]
+}
+
+func (a *FixedAuthority) IsMyTurn(id string, timeStamp int64) bool {
+	return a.GetProducer(timeStamp) == id
+}
+
+func (a *FixedAuthority) IsInSlot(block *blockchain.BlockData) (valid bool, err error) {
+	slotStart, err := slotStartOf(block, a.period, a.epsilon)
+	if err != nil {
+		return
+	}
+	if producer := a.GetProducer(slotStart); producer != block.Signer {
+		return false, fmt.Errorf("slot belongs to %s", producer)
+	}
+	return true, nil
+}
+
+func (a *FixedAuthority) IsTimely(block *blockchain.BlockData, receivedAt int64) (bool, error) {
+	return isTimely(block, receivedAt, a.period, a.epsilon)
+}
+
+//ApplyBlock leaves authorities as they are, misbehaving one has to be removed from configuration
+func (a *FixedAuthority) ApplyBlock(bd blockchain.BlockData) {
+	for _, e := range bd.Evidence {
+		log.Warningf("Authority %s misbehaved: %s\n", e.Offender(), &e)
+	}
+}
+
+func (a *FixedAuthority) RevertBlock(bd blockchain.BlockData) error {
+	return nil
+}
+
+func (a *FixedAuthority) Snapshot() EngineState {
+	return nil
+}
+
+func (a *FixedAuthority) Restore(state EngineState) {}
+
+func (a *FixedAuthority) Reset() {}
+
+func (a *FixedAuthority) SetWeight(id string, weight uint64) {}
+
+//GetStats returns nothing as authorities are never skipped
+func (a *FixedAuthority) GetStats() map[string]ProductionStats {
+	return nil
+}
@@ -0,0 +1,67 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/spf13/viper"
+)
+
+func TestFixedAuthority(t *testing.T) {
+	viper.Set("consensus.engine", "authority")
+	viper.Set("consensus.authorities", []string{"first", "second"})
+	defer viper.Set("consensus.engine", "dpos")
+	engine, err := NewEngine(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	period := engine.Period()
+
+	if !engine.IsMyTurn("first", 4*period) || !engine.IsMyTurn("second", 5*period+period/2) || engine.IsMyTurn("first", period) {
+		t.Error("authorities don't produce in turn")
+	}
+
+	block := &blockchain.BlockData{Unit: blockchain.Unit{Signer: "second", TimeStamp: 3 * period}}
+	if valid, err := engine.IsInSlot(block); !valid {
+		t.Errorf("block produced in turn is invalid: %s", err)
+	}
+	block.Signer = "first"
+	if valid, _ := engine.IsInSlot(block); valid {
+		t.Error("block produced out of turn is valid")
+	}
+	if producer := NewFixedAuthority([]string{"first"}, 10*period).GetProducer(period); producer != "" {
+		t.Errorf("%s produces before genesis", producer)
+	}
+
+	viper.Set("poll.period", 0)
+	if _, err = NewEngine(0); err == nil {
+		t.Error("engine created with zero period")
+	}
+	viper.Set("poll.period", period)
+
+	viper.Set("consensus.authorities", []string{})
+	if _, err = NewEngine(0); err == nil {
+		t.Error("authority engine created without authorities")
+	}
+	viper.Set("consensus.engine", "pow")
+	if _, err = NewEngine(0); err == nil {
+		t.Error("unknown engine created")
+	}
+}
+
+func TestNewEngine_GenesisDelegates(t *testing.T) {
+	viper.Set("poll.maxDelegates", 3)
+	if _, err := NewEngine(0); err == nil {
+		t.Error("dpos engine created without producers to start")
+	}
+
+	viper.Set("poll.genesisDelegates", []string{"genesis"})
+	defer viper.Set("poll.genesisDelegates", []string{})
+	engine, err := NewEngine(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule := engine.GetSchedule(0); len(schedule) != 1 || schedule[0] != "genesis" {
+		t.Errorf("configured genesis delegates ignored: %v", schedule)
+	}
+}
@@ -0,0 +1,118 @@
+package consensus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+)
+
+//Clock is source of time for production and validation, so that it may be controlled in tests and simulations
+type Clock interface {
+	//Now returns current timestamp, nanosec
+	Now() int64
+	Sleep(d time.Duration)
+}
+
+//RealClock is the system clock
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() int64 {
+	return blockchain.GetTimeStamp()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+//AdjustedClock is base clock corrected by offset, e.g. to follow the network time.
+//Correction is bounded, so that peers misreporting their clocks can't move the local one too far.
+type AdjustedClock struct {
+	base   Clock
+	offset func() int64
+	max    int64
+}
+
+//NewAdjustedClock corrects base clock by offset bounded by max, nanosec
+func NewAdjustedClock(base Clock, offset func() int64, max int64) *AdjustedClock {
+	return &AdjustedClock{base, offset, max}
+}
+
+func (c *AdjustedClock) Now() int64 {
+	offset := c.offset()
+	if offset > c.max {
+		offset = c.max
+	} else if offset < -c.max {
+		offset = -c.max
+	}
+	return c.base.Now() + offset
+}
+
+func (c *AdjustedClock) Sleep(d time.Duration) {
+	c.base.Sleep(d)
+}
+
+//ManualClock stays still until advanced explicitly, sleepers wake up when it passes their deadlines
+type ManualClock struct {
+	now      int64
+	sleepers []sleeper
+	lock     sync.Mutex
+	changed  *sync.Cond
+}
+
+type sleeper struct {
+	until int64
+	wake  chan struct{}
+}
+
+func NewManualClock(now int64) *ManualClock {
+	c := &ManualClock{now: now}
+	c.changed = sync.NewCond(&c.lock)
+	return c
+}
+
+func (c *ManualClock) Now() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.lock.Lock()
+	s := sleeper{c.now + int64(d), make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.changed.Broadcast()
+	c.lock.Unlock()
+	<-s.wake
+}
+
+//Advance moves clock forward waking up sleepers whose deadlines passed
+func (c *ManualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now += int64(d)
+	sleeping := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if s.until <= c.now {
+			close(s.wake)
+		} else {
+			sleeping = append(sleeping, s)
+		}
+	}
+	c.sleepers = sleeping
+	c.changed.Broadcast()
+}
+
+//WaitSleepers blocks until n goroutines sleep on the clock, i.e. everything due before the next advance is done
+func (c *ManualClock) WaitSleepers(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.sleepers) < n {
+		c.changed.Wait()
+	}
+}
@@ -0,0 +1,45 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	clock := NewManualClock(1000)
+	woke := make(chan int64)
+	go func() {
+		clock.Sleep(50)
+		woke <- clock.Now()
+	}()
+
+	clock.WaitSleepers(1)
+	clock.Advance(30)
+	select {
+	case <-woke:
+		t.Fatal("sleeper woke up before its deadline")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(30)
+	if now := <-woke; now != 1060 {
+		t.Errorf("sleeper woke up at %d, expected 1060", now)
+	}
+
+	clock.Sleep(0)
+	if clock.Now() != 1060 {
+		t.Errorf("clock moved by itself: %d", clock.Now())
+	}
+}
+
+func TestAdjustedClock_Bound(t *testing.T) {
+	offset := int64(0)
+	clock := NewAdjustedClock(NewManualClock(1000), func() int64 { return offset }, 50)
+
+	for _, c := range []struct{ offset, expected int64 }{{30, 1030}, {80, 1050}, {-80, 950}} {
+		offset = c.offset
+		if clock.Now() != c.expected {
+			t.Errorf("clock adjusted by %d shows %d, expected %d", c.offset, clock.Now(), c.expected)
+		}
+	}
+}
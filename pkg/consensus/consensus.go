@@ -6,17 +6,16 @@ import (
 	"time"
 )
 
-var Epsilon = int64(10 * time.Millisecond) //viper.GetInt64("poll.epsilon")
-
-//period - time between blocks production in seconds, ttp - time to produce ticker
-func StartProduction(poll *Poll, id string) (ttpChan chan struct{}) {
+//StartProduction signals to ttpChan (time to produce) at start of every slot the producer owns, slots are engine
+//periods, nanosec
+func StartProduction(engine Engine, clock Clock, id string) (ttpChan chan struct{}) {
 	ttpChan = make(chan struct{})
 
 	go func(ttpChan chan struct{}) {
 
 		for {
-			time.Sleep(UntilNext(poll.period))
-			if myTurn(id, poll) {
+			clock.Sleep(UntilNext(clock, engine.Period()))
+			if engine.IsMyTurn(id, clock.Now()) {
 				ttpChan <- struct{}{}
 			}
 		}
@@ -26,18 +25,37 @@ func StartProduction(poll *Poll, id string) (ttpChan chan struct{}) {
 	return ttpChan
 }
 
-func UntilNext(period int64) time.Duration {
-	return time.Duration(period - blockchain.GetTimeStamp()%period)
+func UntilNext(clock Clock, period int64) time.Duration {
+	return time.Duration(period - clock.Now()%period)
+}
+
+//MissedSlots returns producers of the empty slots between block and its parent. Slots of one round before the block
+//are checked at most, longer gaps mean network outage rather than producers fault.
+func MissedSlots(engine Engine, parentTimeStamp int64, block *blockchain.BlockData) (missed []string) {
+	period, epsilon := engine.Period(), engine.Epsilon()
+	slotStart, _ := blockchain.SlotStart(block.GetTimestamp(), period, epsilon)
+	from, _ := blockchain.SlotStart(parentTimeStamp, period, epsilon)
+	from += period
+	if earliest := slotStart - period*int64(engine.GetMaxElected()); from < earliest {
+		from = earliest
+	}
+	for ts := from; ts < slotStart; ts += period {
+		if producer := engine.GetProducer(ts); producer != "" {
+			missed = append(missed, producer)
+		}
+	}
+	return
 }
 
-func myTurn(myId string, poll *Poll) bool {
-	position := poll.GetPosition(myId)
+//IsMyTurn tells whether producer owns the slot timeStamp belongs to
+func (p *Poll) IsMyTurn(myId string, timeStamp int64) bool {
+	position := p.GetPosition(myId, timeStamp)
 
 	if position == -1 {
 		return false
 	}
 
-	currentSlot := poll.getSlotAt(blockchain.GetTimeStamp())
+	currentSlot := p.getSlotAt(timeStamp)
 
 	return currentSlot == position
 }
@@ -53,22 +71,57 @@ func (p *Poll) GetCurrentSlotStart(timeStamp int64) int64 {
 	return timeStamp - timeStamp%p.period
 }
 
+func (p *Poll) Epsilon() int64 {
+	return p.epsilon
+}
+
 //Block validation from DPoS perspective: was block produced by right candidate at the right time?
+//Producer is looked for in the round schedule derived from the chain, so all nodes on the same chain agree on it.
+//Used for live announcements, blocks received later are validated with IsInSlot.
 func (p *Poll) IsValid(block *blockchain.BlockData, receivedAt int64) (valid bool, err error) {
-	slot := p.getSlotAt(receivedAt)
-	requiredTS := p.GetCurrentSlotStart(receivedAt)
+	valid, err = p.IsTimely(block, receivedAt)
+	if !valid {
+		return
+	}
+	return p.IsInSlot(block)
+}
 
-	position := p.GetPosition(block.Signer)
+//IsInSlot checks block was produced by the producer scheduled for the slot of the block timestamp in the round schedule
+//that applied then, so that blocks of any age are validated the same way, e.g. during sync.
+//Block timestamp may be off the slot start by epsilon at most.
+func (p *Poll) IsInSlot(block *blockchain.BlockData) (valid bool, err error) {
+	slotStart, err := slotStartOf(block, p.period, p.epsilon)
+	if err != nil {
+		return
+	}
+
+	schedule := p.scheduleAt(p.roundAt(slotStart))
+	if schedule == nil {
+		return false, fmt.Errorf("schedule of round %d is not known", p.roundAt(slotStart))
+	}
+	slot := p.getSlotAt(slotStart)
+	position := getPosition(schedule, block.Signer)
 	if position != slot {
 		return false, fmt.Errorf("not in required slot: %d, producer position = %d", slot, position)
 	}
-	diff := requiredTS - block.GetTimestamp()
-	if diff < 0 {
-		diff = -diff
-	}
-	valid = diff < Epsilon
-	if !valid {
-		err = fmt.Errorf("incorrect timestamp: %d. Required: %d ± %v", block.GetTimestamp(), requiredTS, Epsilon)
+	return true, nil
+}
+
+//IsTimely checks announced block was received within its slot, i.e. clocks of producer and node don't drift apart
+//more than by epsilon
+func (p *Poll) IsTimely(block *blockchain.BlockData, receivedAt int64) (valid bool, err error) {
+	return isTimely(block, receivedAt, p.period, p.epsilon)
+}
+
+//slotStartOf returns start of the slot block was produced in, block timestamp may be off it by epsilon at most
+func slotStartOf(block *blockchain.BlockData, period int64, epsilon int64) (slotStart int64, err error) {
+	return blockchain.SlotStart(block.GetTimestamp(), period, epsilon)
+}
+
+func isTimely(block *blockchain.BlockData, receivedAt int64, period int64, epsilon int64) (valid bool, err error) {
+	slotStart, _ := blockchain.SlotStart(block.GetTimestamp(), period, epsilon)
+	if receivedAt < block.GetTimestamp()-epsilon || receivedAt >= slotStart+period {
+		return false, fmt.Errorf("block with timestamp %d received at %d out of its slot, clocks drift", block.GetTimestamp(), receivedAt)
 	}
-	return
+	return true, nil
 }
@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"fmt"
+	"github.com/alholm/akhcoin/pkg/blockchain"
 	"github.com/spf13/viper"
 	"testing"
 	"time"
@@ -10,15 +11,23 @@ import (
 func ExampleStartProduction() {
 	viper.Set("poll.period", int64(time.Second))
 	poll := doElection()
+	clock := NewManualClock(poll.genesisStart + int64(42742*time.Millisecond))
 
+	produced := make(chan string)
 	for candidate := range winners {
-		startProduction(poll, candidate)
+		startProduction(poll, clock, candidate, produced)
 	}
 	for candidate := range losers {
-		startProduction(poll, candidate)
+		startProduction(poll, clock, candidate, produced)
 	}
 
-	time.Sleep(10100 * time.Millisecond)
+	sleepers := len(winners) + len(losers)
+	clock.WaitSleepers(sleepers)
+	for i := 0; i < 10; i++ {
+		clock.Advance(UntilNext(clock, poll.period))
+		fmt.Println(<-produced)
+		clock.WaitSleepers(sleepers)
+	}
 	// Output:
 	//forthh
 	//fifthh
@@ -32,11 +41,11 @@ func ExampleStartProduction() {
 	//thirdd
 }
 
-func startProduction(poll *Poll, candidate string) {
-	ttpChan := StartProduction(poll, candidate)
+func startProduction(poll *Poll, clock Clock, candidate string, produced chan string) {
+	ttpChan := StartProduction(poll, clock, candidateID(candidate))
 	go func(ttpChan chan struct{}, id string) {
 		for range ttpChan {
-			fmt.Println(id)
+			produced <- id
 		}
 	}(ttpChan, candidate)
 }
@@ -45,15 +54,59 @@ func TestPoll_GetCurrentSlot(t *testing.T) {
 	startTime := getTestStartTime()
 	poll := NewPoll(5, 1, 0, startTime)
 	poll.period = int64(1 * time.Second)
+	clock := NewManualClock(startTime + int64(42742*time.Millisecond))
 
 	expected := []int{2, 3, 4, 4, 0, 1, 1, 2, 3}
 
 	for i := 0; i < 9; i++ {
-		slot := poll.getSlotAt(time.Now().UTC().UnixNano())
+		slot := poll.getSlotAt(clock.Now())
 		if slot != expected[i] {
 			t.Errorf("Got %d slot, expected: %d", slot, expected[i])
 		}
-		time.Sleep(700 * time.Millisecond)
+		clock.Advance(700 * time.Millisecond)
+	}
+
+}
+
+func TestPoll_IsInSlot(t *testing.T) {
+	viper.Set("poll.genesisDelegates", []string{"first", "second", "third"})
+	defer viper.Set("poll.genesisDelegates", []string{})
+	viper.Set("poll.epsilon", int64(5*time.Millisecond))
+	defer viper.Set("poll.epsilon", int64(time.Second))
+	poll := NewPoll(3, 1, 0, 0)
+	if poll.Epsilon() != int64(5*time.Millisecond) {
+		t.Fatalf("configured epsilon ignored: %d", poll.Epsilon())
+	}
+	slotStart := 5*poll.period*int64(poll.maxDelegates) + poll.period //2nd slot of the 5th round
+
+	blocks := []struct {
+		signer    string
+		timeStamp int64
+		valid     bool
+	}{
+		{"second", slotStart, true},
+		{"second", slotStart - poll.Epsilon()/2, true},
+		{"second", slotStart + poll.Epsilon()/2, true},
+		{"second", slotStart + 2*poll.Epsilon(), false},
+		{"first", slotStart, false},
+		{"third", slotStart + poll.period, true},
+	}
+	for _, b := range blocks {
+		block := &blockchain.BlockData{Unit: blockchain.Unit{Signer: b.signer, TimeStamp: b.timeStamp}}
+		valid, err := poll.IsInSlot(block)
+		if valid != b.valid {
+			t.Errorf("block of %s at %d: valid = %t, expected %t: %v", b.signer, b.timeStamp, valid, b.valid, err)
+		}
 	}
 
+	block := &blockchain.BlockData{Unit: blockchain.Unit{Signer: "second", TimeStamp: slotStart}}
+	if valid, err := poll.IsTimely(block, slotStart+poll.period/2); !valid {
+		t.Errorf("block received within its slot is not timely: %s", err)
+	}
+	if valid, _ := poll.IsTimely(block, slotStart+poll.period); valid {
+		t.Error("block received after its slot is timely")
+	}
+	if valid, _ := poll.IsTimely(block, slotStart-2*poll.Epsilon()); valid {
+		t.Error("block received before it was produced is timely")
+	}
 }
@@ -0,0 +1,78 @@
+package consensus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("consensus.engine", "dpos")
+	viper.SetDefault("consensus.authorities", []string{})
+}
+
+//Engine decides who produces blocks and when. Node applies blocks of its chain to the engine, so that engine state
+//follows the chain.
+type Engine interface {
+	//Period is time between blocks, nanosec
+	Period() int64
+	//Epsilon is how far block timestamp may be off its slot start, nanosec
+	Epsilon() int64
+	//GetMaxElected is number of slots in a round
+	GetMaxElected() int
+	GetCurrentSlotStart(timeStamp int64) int64
+	//GetSchedule returns producers of the round timeStamp belongs to in slots order
+	GetSchedule(timeStamp int64) []string
+	//GetProducer returns producer of the slot timeStamp belongs to, empty if nobody produces in it
+	GetProducer(timeStamp int64) string
+	//IsMyTurn tells whether producer owns the slot timeStamp belongs to, it triggers production
+	IsMyTurn(id string, timeStamp int64) bool
+	//IsInSlot checks block of any age was produced by the right producer at the right time
+	IsInSlot(block *blockchain.BlockData) (bool, error)
+	//IsTimely checks announced block was received within its slot
+	IsTimely(block *blockchain.BlockData, receivedAt int64) (bool, error)
+
+	//ApplyBlock updates engine state with block attached to the chain, including evidence of misbehavior it carries
+	ApplyBlock(bd blockchain.BlockData)
+	//RevertBlock cancels the last applied block
+	RevertBlock(bd blockchain.BlockData) error
+	Snapshot() EngineState
+	Restore(state EngineState)
+	//Reset puts engine to the state before any block was applied
+	Reset()
+	//SetWeight updates stake of the account
+	SetWeight(id string, weight uint64)
+	//GetStats returns produced and missed slots of delegates engine judges their reliability by
+	GetStats() map[string]ProductionStats
+}
+
+//EngineState is snapshot of engine state, opaque for node
+type EngineState interface{}
+
+//NewEngine creates engine chosen by consensus.engine config: "dpos" (default) or "authority".
+//DPoS engine requires poll.genesisDelegates producing until votes get to the chain. They are part of network
+//definition, the same for all nodes, as nodes with different ones disagree on schedules.
+func NewEngine(genesisStart int64) (Engine, error) {
+	if viper.GetInt64("poll.period") <= 0 {
+		return nil, fmt.Errorf("poll.period has to be positive")
+	}
+	switch name := viper.GetString("consensus.engine"); name {
+	case "dpos":
+		genesisDelegates := viper.GetStringSlice("poll.genesisDelegates")
+		if len(genesisDelegates) == 0 {
+			return nil, fmt.Errorf("no poll.genesisDelegates set to produce until votes get to the chain")
+		}
+		return newPoll(viper.GetInt("poll.MaxDelegates"), viper.GetInt("poll.MaxVotes"),
+			viper.GetDuration("poll.freezePeriod")*time.Second, genesisStart, genesisDelegates), nil
+	case "authority":
+		authorities := viper.GetStringSlice("consensus.authorities")
+		if len(authorities) == 0 {
+			return nil, fmt.Errorf("no consensus.authorities set for authority engine")
+		}
+		return NewFixedAuthority(authorities, genesisStart), nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine: %s", name)
+	}
+}
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"sync"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+)
+
+//EquivocationDetector remembers headers signed by every producer for recent slots
+//and builds evidence when producer signs another block for the same slot
+type EquivocationDetector struct {
+	headers  map[string]map[int64]blockchain.BlockHeader //by signer and slot number
+	period   int64
+	epsilon  int64
+	keep     int64 //number of recent slots to remember
+	lastSlot int64
+	sync.Mutex
+}
+
+func NewEquivocationDetector(period int64, epsilon int64, keep int64) *EquivocationDetector {
+	return &EquivocationDetector{
+		headers: make(map[string]map[int64]blockchain.BlockHeader),
+		period:  period,
+		epsilon: epsilon,
+		keep:    keep,
+	}
+}
+
+//Observe records header, evidence is returned if its producer has already signed different block for the same slot.
+//Header signature, timeliness and producer of the slot have to be verified beforehand, as header of the future slot
+//makes earlier ones forgotten.
+func (d *EquivocationDetector) Observe(h blockchain.BlockHeader) *blockchain.Equivocation {
+	d.Lock()
+	defer d.Unlock()
+
+	slotStart, err := blockchain.SlotStart(h.GetTimestamp(), d.period, d.epsilon)
+	if err != nil {
+		return nil
+	}
+	slot := slotStart / d.period
+	if slot <= d.lastSlot-d.keep {
+		return nil
+	}
+	if slot > d.lastSlot {
+		d.lastSlot = slot
+		d.prune()
+	}
+
+	slots, ok := d.headers[h.Signer]
+	if !ok {
+		slots = make(map[int64]blockchain.BlockHeader)
+		d.headers[h.Signer] = slots
+	}
+	seen, ok := slots[slot]
+	if !ok {
+		slots[slot] = h
+		return nil
+	}
+	if seen.Hash == h.Hash {
+		return nil
+	}
+	return blockchain.NewEquivocation(seen, h)
+}
+
+func (d *EquivocationDetector) prune() {
+	for signer, slots := range d.headers {
+		for slot := range slots {
+			if slot <= d.lastSlot-d.keep {
+				delete(slots, slot)
+			}
+		}
+		if len(slots) == 0 {
+			delete(d.headers, signer)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alholm/akhcoin/pkg/blockchain"
+	"github.com/spf13/viper"
+)
+
+func TestEquivocationDetector(t *testing.T) {
+	period := int64(10 * time.Second)
+	viper.Set("reward", 1)
+	priv, _, _ := blockchain.NewKeys()
+	genesis := blockchain.CreateGenesis()
+
+	newBlock := func(timeStamp int64, votesN int) *blockchain.BlockData {
+		votes := make([]blockchain.Vote, votesN)
+		for i := range votes {
+			votes[i] = *blockchain.NewVote(priv, "candidate")
+		}
+		block := blockchain.NewBlock(priv, genesis, nil, votes)
+		block.TimeStamp = timeStamp
+		block.Hash = blockchain.Hash(block.GetCorpus().Bytes())
+		block.Sign, _ = priv.Sign(block.GetCorpus().Bytes())
+		return &block.BlockData
+	}
+
+	epsilon := int64(time.Millisecond)
+	d := NewEquivocationDetector(period, epsilon, 3)
+	start := 100 * period
+	first := newBlock(start, 0)
+	if e := d.Observe(first.Header()); e != nil {
+		t.Fatal("evidence built from single block")
+	}
+	if e := d.Observe(first.Header()); e != nil {
+		t.Fatal("evidence built from the same block received twice")
+	}
+	if e := d.Observe(newBlock(start+period, 1).Header()); e != nil {
+		t.Fatal("evidence built from blocks of different slots")
+	}
+
+	//timestamp of the slot may be before period boundary by less than epsilon
+	e := d.Observe(newBlock(start+period-epsilon/2, 2).Header())
+	if e == nil {
+		t.Fatal("conflicting block not detected")
+	}
+	if valid, err := e.Verify(period, epsilon); !valid {
+		t.Fatalf("invalid evidence built: %s", err)
+	}
+	if e := d.Observe(newBlock(start+period/2, 1).Header()); e != nil {
+		t.Fatal("evidence built from block out of any slot")
+	}
+
+	d.Observe(newBlock(start+5*period, 0).Header())
+	if e := d.Observe(newBlock(start, 2).Header()); e != nil {
+		t.Fatal("evidence built for forgotten slot")
+	}
+}
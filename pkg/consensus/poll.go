@@ -1,10 +1,12 @@
 package consensus
 
 import (
+	"fmt"
 	"github.com/alholm/akhcoin/pkg/blockchain"
 	logging "github.com/ipfs/go-log"
 	"github.com/spf13/viper"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -13,58 +15,186 @@ var log = logging.Logger("consensus")
 func init() {
 	viper.SetDefault("poll.period", int64(10*time.Second))
 	viper.SetDefault("poll.epsilon", int64(1*time.Second))
+	viper.SetDefault("poll.genesisDelegates", []string{})
+	viper.SetDefault("poll.maxMissRate", 0.0)
 }
 
+//keptSchedules is number of last rounds with blocks which schedules are kept to validate blocks against
+const keptSchedules = 64
+
+//revertableBlocks is number of last applied blocks poll keeps undo records for, deeper reorgs require replaying the chain
+const revertableBlocks = 256
+
+//judgedSlots is number of slots delegate has to be scheduled for before its miss rate counts
+const judgedSlots = 10
+
+//Poll is DPoS engine: stake weighted votes included in the chain elect producers of the next rounds
 type Poll struct {
-	votesChan      chan blockchain.Vote
 	candidatesChan chan struct {
 		id    string
-		votes int
+		votes int64
+	}
+	weightsChan chan struct {
+		id     string
+		weight int64
+	}
+	blocksChan chan struct {
+		bd   blockchain.BlockData
+		done chan struct{}
 	}
-	newRoundChan chan struct{}
-	votes        map[string]VoterInfo
-	top          []Candidate
-	maxDelegates int
-	maxVotes     int
-	freezePeriod time.Duration
-	genesisStart int64
-	period       int64
+	revertChan chan struct {
+		bd     blockchain.BlockData
+		result chan error
+	}
+	restoreChan chan struct {
+		snapshot *Snapshot
+		done     chan struct{}
+	}
+	snapshotChan     chan chan *Snapshot
+	statsChan        chan chan map[string]ProductionStats
+	votes            map[string]VoterInfo
+	weights          map[string]int64           //current weight of every voter, survives rounds
+	disqualified     map[string]bool            //producers caught on equivocation, never elected again
+	top              []Candidate                //current standings, changes with every vote
+	genesisDelegates []Candidate                //produce while no votes got to the chain
+	round            int64                      //round of the last applied block
+	schedules        []roundSchedule            //schedules of the last rounds blocks were applied in, ascending
+	pruned           bool                       //schedules of old rounds are dropped
+	next             []Candidate                //copy of current standings, schedule of rounds no block applied in yet
+	applied          []undoRecord               //last applied blocks, ascending
+	initial          *Snapshot                  //state before genesis, poll is reset to
+	stats            map[string]ProductionStats //slots of delegates since they were skipped last time
+	lastBlock        int64                      //timestamp of the last applied block
+	maxMissRate      float64                    //delegates missing larger share of their slots skip a round, 0 - never
+	scheduleLock     sync.RWMutex               //guards schedules, pruned and next read outside of poll goroutine
+	maxDelegates     int
+	maxVotes         int
+	freezePeriod     time.Duration
+	genesisStart     int64
+	period           int64
+	epsilon          int64
+}
+
+//roundSchedule is order of producers in the round, fixed by votes of blocks from previous rounds
+type roundSchedule struct {
+	round    int64
+	schedule []Candidate
+}
+
+//Snapshot is poll state after some block, poll restored from it continues with the blocks after that one
+type Snapshot struct {
+	votes            map[string]VoterInfo
+	weights          map[string]int64
+	disqualified     map[string]bool
+	top              []Candidate
+	genesisDelegates []Candidate
+	round            int64
+	schedules        []roundSchedule
+	pruned           bool
+	stats            map[string]ProductionStats
+	lastBlock        int64
+}
+
+//ProductionStats counts slots delegate was scheduled for
+type ProductionStats struct {
+	Produced int64
+	Missed   int64
+}
+
+//undoRecord keeps poll state changed by the block to revert it when block gets disconnected from the chain
+type undoRecord struct {
+	hash          string
+	previousRound int64
+	newRound      bool                 //block was the first of its round and added schedule
+	voters        map[string]VoterInfo //voters state before the block
+	lastBlock     int64
+	stats         map[string]ProductionStats //stats of delegates changed by the block before it
+	disqualified  []string                   //producers disqualified by evidence of the block
 }
 
 func (p *Poll) Period() int64 {
 	return p.period
 }
 
+//Candidate votes is total weight of voters voted for it
 type Candidate struct {
 	id    string
-	votes int
+	votes int64
 }
 
 type VoterInfo struct {
-	votes     int
+	votes     int64 //total weight of votes for the candidate
+	weight    int64 //weight voter's votes were counted with
 	votedFor  []string
 	timeStamp int64
 }
 
 //Creates new structure that counts incoming votes and maintains list of maxDelegates top voted candidates.
 //maxVotes is number of candidates one is allowed to vote for.
-//freezePeriod is time required to elapse before voter can vote again
+//freezePeriod is time required to elapse before voter can vote again.
+//Configured poll.genesisDelegates produce until votes get to the chain.
 func NewPoll(maxDelegates int, maxVotes int, freezePeriod time.Duration, genesisStart int64) *Poll {
+	return newPoll(maxDelegates, maxVotes, freezePeriod, genesisStart, viper.GetStringSlice("poll.genesisDelegates"))
+}
+
+func newPoll(maxDelegates int, maxVotes int, freezePeriod time.Duration, genesisStart int64, genesisIDs []string) *Poll {
 	log.Debugf("New Poll config: md = %d, mv = %d, fp = %v, p = %d", maxDelegates, maxVotes, freezePeriod, viper.GetInt64("poll.period"))
 	votes := make(map[string]VoterInfo)
 	top := make([]Candidate, 0, maxDelegates)
 
 	candidatesChan := make(chan struct {
 		id    string
-		votes int
+		votes int64
 	}, 2)
+	weightsChan := make(chan struct {
+		id     string
+		weight int64
+	})
+
+	blocksChan := make(chan struct {
+		bd   blockchain.BlockData
+		done chan struct{}
+	})
+	genesisDelegates := make([]Candidate, 0, maxDelegates)
+	for _, id := range genesisIDs {
+		genesisDelegates = append(genesisDelegates, Candidate{id: id})
+	}
+
+	revertChan := make(chan struct {
+		bd     blockchain.BlockData
+		result chan error
+	})
+
+	restoreChan := make(chan struct {
+		snapshot *Snapshot
+		done     chan struct{}
+	})
 
 	poll := &Poll{
-		make(chan blockchain.Vote),
-		candidatesChan,
-		make(chan struct{}),
-		votes, top, maxDelegates, maxVotes, freezePeriod,
-		genesisStart, viper.GetInt64("poll.period")}
+		candidatesChan:   candidatesChan,
+		weightsChan:      weightsChan,
+		blocksChan:       blocksChan,
+		revertChan:       revertChan,
+		snapshotChan:     make(chan chan *Snapshot),
+		statsChan:        make(chan chan map[string]ProductionStats),
+		restoreChan:      restoreChan,
+		votes:            votes,
+		weights:          make(map[string]int64),
+		disqualified:     make(map[string]bool),
+		top:              top,
+		genesisDelegates: genesisDelegates,
+		schedules:        make([]roundSchedule, 0, keptSchedules),
+		stats:            make(map[string]ProductionStats),
+		lastBlock:        genesisStart,
+		maxMissRate:      viper.GetFloat64("poll.maxMissRate"),
+		maxDelegates:     maxDelegates,
+		maxVotes:         maxVotes,
+		freezePeriod:     freezePeriod,
+		genesisStart:     genesisStart,
+		period:           viper.GetInt64("poll.period"),
+		epsilon:          viper.GetInt64("poll.epsilon")}
+	poll.next = poll.standings()
+	poll.initial = poll.snapshot()
 
 	go poll.startListening()
 
@@ -80,68 +210,346 @@ func (p *Poll) processVote(vote blockchain.Vote) {
 	}
 
 	candidate := vote.Candidate
-	for _, votedFor := range voterInfo.votedFor {
+	position := -1
+	for i, votedFor := range voterInfo.votedFor {
 		if votedFor == candidate {
+			position = i
+			break
+		}
+	}
+
+	if vote.Withdraw {
+		if position == -1 {
 			return
 		}
+		voterInfo.votedFor = append(voterInfo.votedFor[:position], voterInfo.votedFor[position+1:]...)
+		voterInfo.timeStamp = vote.GetTimestamp()
+		p.votes[voter] = voterInfo
+		p.countVotes(candidate, -voterInfo.weight)
+		return
+	}
+	if position != -1 {
+		return
 	}
 
 	voterInfo.votedFor = append(voterInfo.votedFor, candidate)
 
 	if len(voterInfo.votedFor) > p.maxVotes {
-		p.submitCandidate(voterInfo.votedFor[0], -1)
+		p.countVotes(voterInfo.votedFor[0], -voterInfo.weight)
 		voterInfo.votedFor = append(voterInfo.votedFor[:0], voterInfo.votedFor[1:]...)
 	}
+	voterInfo.weight = p.weights[voter]
 	voterInfo.timeStamp = vote.GetTimestamp()
 	p.votes[voter] = voterInfo
 
-	p.submitCandidate(candidate, 1)
+	p.countVotes(candidate, voterInfo.weight)
+
+}
+
+//processWeight moves votes of the voter after its weight changed
+func (p *Poll) processWeight(voter string, weight int64) {
+	p.weights[voter] = weight
+	voterInfo, ok := p.votes[voter]
+	if !ok || len(voterInfo.votedFor) == 0 {
+		return
+	}
+	delta := weight - voterInfo.weight
+	voterInfo.weight = weight
+	p.votes[voter] = voterInfo
+	for _, candidate := range voterInfo.votedFor {
+		p.countVotes(candidate, delta)
+	}
+	p.rebuildTop()
+}
+
+//applyBlock fixes schedule of the block round if it is the first block of the round, then counts block votes.
+//Schedule of a round thereby depends only on votes of blocks from previous rounds and is the same on every node having the chain.
+func (p *Poll) applyBlock(bd blockchain.BlockData) {
+	undo := undoRecord{hash: bd.Hash, previousRound: p.round, voters: make(map[string]VoterInfo),
+		lastBlock: p.lastBlock, stats: make(map[string]ProductionStats)}
+	round := p.roundAt(bd.GetTimestamp())
+	if round > p.round || len(p.schedules) == 0 {
+		undo.newRound = true
+		p.scheduleLock.Lock()
+		p.schedules = append(p.schedules, roundSchedule{round, p.standings()})
+		if len(p.schedules) > keptSchedules {
+			p.schedules = append(p.schedules[:0], p.schedules[1:]...)
+			p.pruned = true
+		}
+		p.scheduleLock.Unlock()
+		p.round = round
+		//unreliable delegates skipped the round start over
+		for id, stats := range p.stats {
+			if p.unreliable(stats) {
+				undo.saveStats(id, stats)
+				delete(p.stats, id)
+			}
+		}
+	}
+	for _, id := range MissedSlots(p, p.lastBlock, &bd) {
+		undo.saveStats(id, p.stats[id])
+		stats := p.stats[id]
+		stats.Missed++
+		p.stats[id] = stats
+	}
+	undo.saveStats(bd.Signer, p.stats[bd.Signer])
+	stats := p.stats[bd.Signer]
+	stats.Produced++
+	p.stats[bd.Signer] = stats
+	p.lastBlock = bd.GetTimestamp()
+
+	//schedule of the block round is fixed already, offender is out from the next round on
+	for _, e := range bd.Evidence {
+		if offender := e.Offender(); !p.disqualified[offender] {
+			log.Warningf("Producer %s disqualified: %s\n", offender, &e)
+			p.disqualified[offender] = true
+			undo.disqualified = append(undo.disqualified, offender)
+		}
+	}
+
+	for _, vote := range bd.Votes {
+		if _, ok := undo.voters[vote.Signer]; !ok {
+			info := p.votes[vote.Signer]
+			info.votedFor = append([]string(nil), info.votedFor...)
+			undo.voters[vote.Signer] = info
+		}
+		p.processVote(vote)
+	}
+	//incremental top updates order equally voted candidates by arrival, rebuilding puts them in the same order every
+	//node has no matter whether it applied or reverted blocks
+	p.rebuildTop()
+
+	p.applied = append(p.applied, undo)
+	if len(p.applied) > revertableBlocks {
+		p.applied = append(p.applied[:0], p.applied[1:]...)
+	}
+}
+
+//revertBlock returns votes of the last applied block back to the state before it, weights of voters stay current
+func (p *Poll) revertBlock(bd blockchain.BlockData) (err error) {
+	if len(p.applied) == 0 || p.applied[len(p.applied)-1].hash != bd.Hash {
+		return fmt.Errorf("block %s is not the last applied one or too old to revert", bd.Hash)
+	}
+	undo := p.applied[len(p.applied)-1]
+	p.applied = p.applied[:len(p.applied)-1]
+
+	for _, id := range undo.disqualified {
+		delete(p.disqualified, id)
+	}
+
+	for voter, previous := range undo.voters {
+		current := p.votes[voter]
+		for _, candidate := range current.votedFor {
+			p.countVotes(candidate, -current.weight)
+		}
+		previous.votes = current.votes //candidate own votes are counted apart from its voter state
+		previous.weight = p.weights[voter]
+		p.votes[voter] = previous
+		for _, candidate := range previous.votedFor {
+			p.countVotes(candidate, previous.weight)
+		}
+	}
+	p.rebuildTop()
+
+	for id, stats := range undo.stats {
+		if stats == (ProductionStats{}) {
+			delete(p.stats, id)
+		} else {
+			p.stats[id] = stats
+		}
+	}
+	p.lastBlock = undo.lastBlock
+
+	if undo.newRound {
+		p.scheduleLock.Lock()
+		if len(p.schedules) > 0 {
+			p.schedules = p.schedules[:len(p.schedules)-1]
+		}
+		p.scheduleLock.Unlock()
+	}
+	p.round = undo.previousRound
+	return
+}
+
+//countVotes adds votes weight to the candidate and moves it in the top, called on poll goroutine only
+func (p *Poll) countVotes(id string, votes int64) {
+	candidateInfo := p.votes[id]
+
+	candidateInfo.votes += votes
+	votesN := candidateInfo.votes
+	p.votes[id] = candidateInfo
 
+	if !p.disqualified[id] {
+		p.updateTop(Candidate{id, votesN})
+	}
 }
 
-func (p *Poll) submitCandidate(id string, votes int) {
+//standings returns copy of the top, genesis delegates if nobody got votes yet. Disqualified delegates are skipped,
+//unreliable ones too unless nobody is left to produce then.
+func (p *Poll) standings() []Candidate {
+	candidates := p.top
+	if len(p.top) == 0 {
+		candidates = p.genesisDelegates
+	}
+	qualified := make([]Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if !p.disqualified[c.id] {
+			qualified = append(qualified, c)
+		}
+	}
+	standings := make([]Candidate, 0, len(qualified))
+	for _, c := range qualified {
+		if !p.unreliable(p.stats[c.id]) {
+			standings = append(standings, c)
+		}
+	}
+	if len(standings) == 0 {
+		return qualified
+	}
+	return standings
+}
+
+//unreliable tells whether delegate missed too many of its slots
+func (p *Poll) unreliable(stats ProductionStats) bool {
+	scheduled := stats.Produced + stats.Missed
+	return p.maxMissRate > 0 && scheduled >= judgedSlots && float64(stats.Missed) > p.maxMissRate*float64(scheduled)
+}
+
+//saveStats keeps delegate stats before the block, the earliest ones only
+func (u *undoRecord) saveStats(id string, stats ProductionStats) {
+	if _, ok := u.stats[id]; !ok {
+		u.stats[id] = stats
+	}
+}
+
+func (p *Poll) submitCandidate(id string, votes int64) {
 	go func() {
 		p.candidatesChan <- struct {
 			id    string
-			votes int
+			votes int64
 		}{id, votes}
 	}()
 }
 
 func (p *Poll) startListening() {
 	for {
+		var reply func() //answers caller once standings are updated
 		select {
-		case vote := <-p.votesChan:
-			p.processVote(vote)
+		case w := <-p.weightsChan:
+			p.processWeight(w.id, w.weight)
 
 		case candidate := <-p.candidatesChan:
-			candidateInfo := p.votes[candidate.id]
+			p.countVotes(candidate.id, candidate.votes)
+
+			//log.Debugf("-> %s = %d ; %v", candidate.id, votesN, p.top)
 
-			candidateInfo.votes += candidate.votes
-			votesN := candidateInfo.votes
-			p.votes[candidate.id] = candidateInfo
+		case b := <-p.blocksChan:
+			p.applyBlock(b.bd)
+			reply = func() { close(b.done) }
 
-			p.updateTop(Candidate{candidate.id, votesN})
+		case b := <-p.revertChan:
+			err := p.revertBlock(b.bd)
+			reply = func() { b.result <- err }
 
-			//log.Debugf("-> %s = %d ; %v", candidate.id, votesN, p.top)
+		case response := <-p.snapshotChan:
+			response <- p.snapshot()
 
-		case <-p.newRoundChan:
-			//TODO consider clearing by range deletion to decrease GC load
-			p.votes = make(map[string]VoterInfo)
-			p.top = make([]Candidate, 0, p.maxDelegates)
+		case response := <-p.statsChan:
+			stats := make(map[string]ProductionStats, len(p.stats))
+			for id, s := range p.stats {
+				stats[id] = s
+			}
+			response <- stats
+
+		case r := <-p.restoreChan:
+			p.restore(r.snapshot)
+			reply = func() { close(r.done) }
+		}
+
+		p.scheduleLock.Lock()
+		p.next = p.standings()
+		p.scheduleLock.Unlock()
+		if reply != nil {
+			reply()
 		}
 	}
 }
 
-//Returns minimal number of votes required to be elected in current round, i.e number of votes for last candidate
-func (p *Poll) minVotes() int {
-	if len(p.top) == cap(p.top) {
-		return p.top[len(p.top)-1].votes
+//snapshot returns copy of poll state, called on poll goroutine only
+func (p *Poll) snapshot() *Snapshot {
+	return (&Snapshot{p.votes, p.weights, p.disqualified, p.top, p.genesisDelegates, p.round, p.schedules, p.pruned,
+		p.stats, p.lastBlock}).clone()
+}
+
+//restore replaces poll state with copy of the snapshot, undo records are dropped as they belong to other blocks
+func (p *Poll) restore(snapshot *Snapshot) {
+	s := snapshot.clone()
+	p.votes, p.weights, p.disqualified = s.votes, s.weights, s.disqualified
+	p.top, p.genesisDelegates, p.round = s.top, s.genesisDelegates, s.round
+	p.stats, p.lastBlock = s.stats, s.lastBlock
+	p.applied = p.applied[:0]
+	p.scheduleLock.Lock()
+	p.schedules, p.pruned = s.schedules, s.pruned
+	p.scheduleLock.Unlock()
+}
+
+func (s *Snapshot) clone() *Snapshot {
+	c := &Snapshot{
+		votes:            make(map[string]VoterInfo, len(s.votes)),
+		weights:          make(map[string]int64, len(s.weights)),
+		disqualified:     make(map[string]bool, len(s.disqualified)),
+		top:              append(make([]Candidate, 0, cap(s.top)), s.top...),
+		genesisDelegates: append([]Candidate(nil), s.genesisDelegates...),
+		round:            s.round,
+		schedules:        make([]roundSchedule, 0, keptSchedules),
+		pruned:           s.pruned,
+		stats:            make(map[string]ProductionStats, len(s.stats)),
+		lastBlock:        s.lastBlock,
+	}
+	for voter, info := range s.votes {
+		info.votedFor = append([]string(nil), info.votedFor...)
+		c.votes[voter] = info
+	}
+	for voter, weight := range s.weights {
+		c.weights[voter] = weight
+	}
+	for id := range s.disqualified {
+		c.disqualified[id] = true
+	}
+	for id, stats := range s.stats {
+		c.stats[id] = stats
+	}
+	for _, rs := range s.schedules {
+		c.schedules = append(c.schedules, roundSchedule{rs.round, append([]Candidate(nil), rs.schedule...)})
 	}
-	return 0
+	return c
+}
+
+func (p *Poll) roundAt(timeStamp int64) int64 {
+	return (timeStamp - p.genesisStart) / (p.period * int64(p.maxDelegates))
+}
+
+//scheduleAt returns producers order of the round, nil if the round is too old to be known
+func (p *Poll) scheduleAt(round int64) []Candidate {
+	p.scheduleLock.RLock()
+	defer p.scheduleLock.RUnlock()
+	if len(p.schedules) > 0 && round < p.schedules[0].round && p.pruned {
+		return nil
+	}
+	//round without blocks has the same schedule as the next round with blocks, as no votes were applied in between
+	i := sort.Search(len(p.schedules), func(i int) bool { return p.schedules[i].round >= round })
+	if i == len(p.schedules) {
+		return p.next
+	}
+	return p.schedules[i].schedule
 }
 
 func (p *Poll) updateTop(newCandidate Candidate) {
+	if position := getPosition(p.top, newCandidate.id); position != -1 && newCandidate.votes < p.top[position].votes {
+		//candidate may go down or leave the top, giving way to one outside of it
+		p.rebuildTop()
+		return
+	}
 
 	if len(p.top) == p.maxDelegates && newCandidate.votes <= p.top[p.maxDelegates-1].votes {
 		return
@@ -167,6 +575,26 @@ func (p *Poll) updateTop(newCandidate Candidate) {
 	}
 }
 
+//rebuildTop orders all candidates by total weight of their votes, the heaviest maxDelegates of them get to the top
+func (p *Poll) rebuildTop() {
+	candidates := make([]Candidate, 0, len(p.votes))
+	for id, info := range p.votes {
+		if info.votes > 0 && !p.disqualified[id] {
+			candidates = append(candidates, Candidate{id, info.votes})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].votes != candidates[j].votes {
+			return candidates[i].votes > candidates[j].votes
+		}
+		return candidates[i].id < candidates[j].id
+	})
+	if len(candidates) > p.maxDelegates {
+		candidates = candidates[:p.maxDelegates]
+	}
+	p.top = append(p.top[:0], candidates...)
+}
+
 func getPosition(top []Candidate, candidateId string) int {
 	position := -1
 	for i := 0; i < len(top); i++ {
@@ -178,33 +606,90 @@ func getPosition(top []Candidate, candidateId string) int {
 	return position
 }
 
-func (p *Poll) IsElected(candidate string) (result bool) {
-	if len(p.top) == 0 {
-		return
+//IsElected tells whether candidate is in schedule of the round timeStamp belongs to
+func (p *Poll) IsElected(candidate string, timeStamp int64) bool {
+	return p.GetPosition(candidate, timeStamp) != -1
+}
+
+//GetPosition returns candidate slot in schedule of the round timeStamp belongs to, -1 if it doesn't produce that round
+func (p *Poll) GetPosition(candidate string, timeStamp int64) int {
+	return getPosition(p.scheduleAt(p.roundAt(timeStamp)), candidate)
+}
+
+//GetProducer returns producer of the slot timeStamp belongs to, empty if nobody is scheduled for it
+func (p *Poll) GetProducer(timeStamp int64) string {
+	schedule := p.scheduleAt(p.roundAt(timeStamp))
+	if slot := p.getSlotAt(timeStamp); slot < len(schedule) {
+		return schedule[slot].id
 	}
-	votesN := p.votes[candidate].votes
-	if votesN >= p.minVotes() {
-		result = true
+	return ""
+}
+
+//GetSchedule returns IDs of producers of the round timeStamp belongs to in slots order
+func (p *Poll) GetSchedule(timeStamp int64) (ids []string) {
+	for _, c := range p.scheduleAt(p.roundAt(timeStamp)) {
+		ids = append(ids, c.id)
 	}
 	return
 }
 
-func (p *Poll) GetPosition(candidate string) int {
-	if len(p.top) == 0 || !p.IsElected(candidate) {
-		return -1
-	}
-	return getPosition(p.top, candidate)
+//ApplyBlock counts votes of the block attached to the chain and disqualifies producers it has evidence against,
+//blocks must be applied in chain order. Blocks are the only source of poll state, so it doesn't depend on the order
+//votes and evidence were gossiped in.
+func (p *Poll) ApplyBlock(bd blockchain.BlockData) {
+	done := make(chan struct{})
+	p.blocksChan <- struct {
+		bd   blockchain.BlockData
+		done chan struct{}
+	}{bd, done}
+	<-done
 }
 
-func (p *Poll) SubmitVote(vote blockchain.Vote) (err error) {
-	//TODO
-	//if no active round err = ...; return
-	p.votesChan <- vote
-	return
+//Snapshot returns copy of poll state to restore it later without replaying the chain
+func (p *Poll) Snapshot() EngineState {
+	response := make(chan *Snapshot)
+	p.snapshotChan <- response
+	return <-response
+}
+
+//GetStats returns slots of delegates since they were skipped last time, the ones miss rate is judged by
+func (p *Poll) GetStats() map[string]ProductionStats {
+	response := make(chan map[string]ProductionStats)
+	p.statsChan <- response
+	return <-response
+}
+
+//Restore puts poll to the state of the snapshot, blocks applied before can't be reverted after it
+func (p *Poll) Restore(state EngineState) {
+	done := make(chan struct{})
+	p.restoreChan <- struct {
+		snapshot *Snapshot
+		done     chan struct{}
+	}{state.(*Snapshot), done}
+	<-done
+}
+
+//Reset puts poll to the initial state, before any block was applied
+func (p *Poll) Reset() {
+	p.Restore(p.initial)
+}
+
+//RevertBlock discounts votes of the block disconnected from the chain, blocks must be reverted in reverse chain order
+func (p *Poll) RevertBlock(bd blockchain.BlockData) error {
+	result := make(chan error)
+	p.revertChan <- struct {
+		bd     blockchain.BlockData
+		result chan error
+	}{bd, result}
+	return <-result
 }
 
-func (p *Poll) StartNewRound() {
-	p.newRoundChan <- struct{}{}
+//SetWeight updates weight of voter votes, e.g. after its balance changed
+func (p *Poll) SetWeight(voter string, weight uint64) {
+	p.weightsChan <- struct {
+		id     string
+		weight int64
+	}{voter, int64(weight)}
 }
 
 func (p *Poll) GetMaxElected() int {
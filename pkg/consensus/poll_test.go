@@ -1,11 +1,12 @@
 package consensus
 
 import (
+	"fmt"
 	"github.com/alholm/akhcoin/pkg/blockchain"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-crypto"
 	"github.com/libp2p/go-libp2p-peer"
-	"sync"
+	"github.com/spf13/viper"
 	"testing"
 	"time"
 )
@@ -19,53 +20,75 @@ func init() {
 
 }
 
+//candidateID returns peer ID of the candidate named in test
+func candidateID(name string) string {
+	return peer.ID(name).Pretty()
+}
+
 func doElection() *Poll {
 	poll := NewPoll(5, 1, 3*time.Second, getTestStartTime())
-	var wg sync.WaitGroup
-	wg.Add(8)
 	for candidate, votes := range winners {
-		go poll.voteForNTimes(candidate, votes, &wg)
+		voteFor(poll, candidate, votes)
 	}
 	for candidate, votes := range losers {
-		go poll.voteForNTimes(candidate, votes, &wg)
+		voteFor(poll, candidate, votes)
 	}
-	wg.Wait()
-	time.Sleep(100 * time.Millisecond)
+	nextRound(poll)
 	return poll
 }
 
 func TestPoll_IsElected(t *testing.T) {
 	poll := doElection()
+	now := poll.genesisStart + state(poll).round*poll.period*int64(poll.maxDelegates)
 
 	for candidate := range winners {
-		if !poll.IsElected(candidate) {
-			t.Fatalf("%s not elected: %v\n", candidate, poll.top)
+		if !poll.IsElected(candidateID(candidate), now) {
+			t.Fatalf("%s not elected: %v\n", candidate, poll.GetSchedule(now))
 		}
 	}
 
 	for candidate := range losers {
-		if poll.IsElected(candidate) {
-			t.Fatalf("%s is elected: %v\n", candidate, poll.top)
+		if poll.IsElected(candidateID(candidate), now) {
+			t.Fatalf("%s is elected: %v\n", candidate, poll.GetSchedule(now))
 		}
 	}
 
-	poll.StartNewRound()
+	//schedule doesn't follow standings until the next round, standings are expected schedule of the next one
+	voteFor(poll, "loser1", 30)
+	if poll.IsElected(candidateID("loser1"), now) || !poll.IsElected(candidateID("fifthh"), now) {
+		t.Fatalf("schedule changed within round: %v", poll.GetSchedule(now))
+	}
+	if poll.GetPosition(candidateID("loser1"), now+poll.period*int64(poll.maxDelegates)) != 0 {
+		t.Fatalf("next round schedule doesn't follow standings: %v", poll.GetSchedule(now+poll.period*int64(poll.maxDelegates)))
+	}
 
-	time.Sleep(100 * time.Millisecond)
+	next := nextRound(poll)
 
-	votesLen := len(poll.votes)
-	topLen := len(poll.top)
-	if votesLen != 0 && topLen != 0 {
-		t.Fatalf("new round not started: votes len = %d, top len = %d", votesLen, topLen)
+	s := state(poll)
+	if s.votes[candidateID("winner")].votes != int64(winners["winner"]) || s.votes[candidateID("loser1")].votes != 31 {
+		t.Fatalf("votes not kept across rounds: %v", s.votes)
+	}
+	if !poll.IsElected(candidateID("loser1"), next) || poll.IsElected(candidateID("fifthh"), next) ||
+		poll.GetPosition(candidateID("loser1"), next) != 0 {
+		t.Fatalf("new round schedule not taken: %v", poll.GetSchedule(next))
+	}
+	if !poll.IsElected(candidateID("fifthh"), now) {
+		t.Fatalf("previous round schedule changed: %v", poll.GetSchedule(now))
 	}
 
 }
 
-func (p *Poll) voteForNTimes(candidate string, n int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for i := 0; i < n; i++ {
-		p.submitCandidate(candidate, 1)
-	}
+//voteFor includes vote for the candidate by new voter, weighted with votes
+func voteFor(poll *Poll, candidate string, votes int) {
+	private, public, _ := blockchain.NewKeys()
+	voter, _ := peer.IDFromPublicKey(public)
+	poll.SetWeight(voter.Pretty(), uint64(votes))
+	includeVotes(poll, *blockchain.NewVote(private, peer.ID(candidate)))
+}
+
+//state returns poll state as seen by poll goroutine, so that test doesn't race with it
+func state(poll *Poll) *Snapshot {
+	return poll.Snapshot().(*Snapshot)
 }
 
 func TestPoll_ProcessVote(t *testing.T) {
@@ -78,47 +101,306 @@ func TestPoll_ProcessVote(t *testing.T) {
 		peerId, _ := peer.IDFromPublicKey(public)
 		privates[i] = private
 		peerIds[i] = peerId
+		poll.SetWeight(peerId.Pretty(), 1)
 	}
 
-	poll.SubmitVote(*blockchain.NewVote(privates[0], peerIds[1]))
-	poll.SubmitVote(*blockchain.NewVote(privates[1], peerIds[2]))
-	poll.SubmitVote(*blockchain.NewVote(privates[2], peerIds[0]))
-	time.Sleep(10 * time.Millisecond)
-	if poll.votes[peerIds[0].Pretty()].votes == 0 ||
-		poll.votes[peerIds[1].Pretty()].votes == 0 ||
-		poll.votes[peerIds[2].Pretty()].votes == 0 {
+	includeVotes(poll, *blockchain.NewVote(privates[0], peerIds[1]))
+	includeVotes(poll, *blockchain.NewVote(privates[1], peerIds[2]))
+	includeVotes(poll, *blockchain.NewVote(privates[2], peerIds[0]))
+	s := state(poll)
+	if s.votes[peerIds[0].Pretty()].votes == 0 ||
+		s.votes[peerIds[1].Pretty()].votes == 0 ||
+		s.votes[peerIds[2].Pretty()].votes == 0 {
 		t.Fatal("poll.votes filled incorrectly")
 	}
-	poll.SubmitVote(*blockchain.NewVote(privates[1], peerIds[0]))
-	time.Sleep(10 * time.Millisecond)
-	if poll.votes[peerIds[0].Pretty()].votes != 1 {
-		t.Fatalf("freezePeriod ignored: %d", poll.votes[peerIds[0].Pretty()].votes)
+	frozen := *blockchain.NewVote(privates[1], peerIds[0])
+	includeVotes(poll, frozen)
+	if s = state(poll); s.votes[peerIds[0].Pretty()].votes != 1 {
+		t.Fatalf("freezePeriod ignored: %d", s.votes[peerIds[0].Pretty()].votes)
 	}
-	time.Sleep(1010 * time.Millisecond)
-	poll.SubmitVote(*blockchain.NewVote(privates[1], peerIds[0]))
-	time.Sleep(10 * time.Millisecond)
-	if poll.votes[peerIds[0].Pretty()].votes != 2 {
-		t.Fatalf("wrong freezePeriod handling: %d", poll.votes[peerIds[0].Pretty()].votes)
+	//freeze period is counted by votes timestamps
+	unfrozen := frozen
+	unfrozen.TimeStamp += int64(1010 * time.Millisecond)
+	includeVotes(poll, unfrozen)
+	if s = state(poll); s.votes[peerIds[0].Pretty()].votes != 2 {
+		t.Fatalf("wrong freezePeriod handling: %d", s.votes[peerIds[0].Pretty()].votes)
 	}
 
-	if len(poll.votes[peerIds[1].Pretty()].votedFor) != 2 {
-		t.Fatalf("voted for filled incorrectly: %v", poll.votes[peerIds[1].Pretty()].votedFor)
+	if len(s.votes[peerIds[1].Pretty()].votedFor) != 2 {
+		t.Fatalf("voted for filled incorrectly: %v", s.votes[peerIds[1].Pretty()].votedFor)
 	}
 
-	time.Sleep(1010 * time.Millisecond)
 	vote := *blockchain.NewVote(privates[1], peerIds[1])
-	poll.SubmitVote(vote) //self voting should be prevented on the upper level
-	time.Sleep(10 * time.Millisecond)
-	votedFor := poll.votes[peerIds[1].Pretty()].votedFor
+	vote.TimeStamp = unfrozen.TimeStamp + int64(1010*time.Millisecond)
+	includeVotes(poll, vote) //self voting should be prevented on the upper level
+	s = state(poll)
+	votedFor := s.votes[peerIds[1].Pretty()].votedFor
 	if len(votedFor) != 2 && votedFor[0] != peerIds[0].Pretty() && votedFor[1] != peerIds[1].Pretty() {
-		t.Fatalf("voted for changed incorrectly: %v", poll.votes[peerIds[1].Pretty()].votedFor)
+		t.Fatalf("voted for changed incorrectly: %v", votedFor)
 	}
 
-	if poll.votes[peerIds[2].Pretty()].votes != 0 {
+	if s.votes[peerIds[2].Pretty()].votes != 0 {
 		t.Fatal("Vote changing didn't reflect first voted candidate")
 	}
 }
 
+func TestPoll_StakeWeight(t *testing.T) {
+	poll := NewPoll(2, 1, 0, 0)
+	privates := make([]crypto.PrivKey, 4)
+	ids := make([]string, 4)
+	for i := range privates {
+		private, public, _ := blockchain.NewKeys()
+		peerId, _ := peer.IDFromPublicKey(public)
+		privates[i] = private
+		ids[i] = peerId.Pretty()
+	}
+	candidates := []peer.ID{"rich", "poor", "third"}
+	rich, poor, third := candidates[0].Pretty(), candidates[1].Pretty(), candidates[2].Pretty()
+
+	poll.SetWeight(ids[0], 100)
+	poll.SetWeight(ids[1], 10)
+	poll.SetWeight(ids[2], 20)
+	//free key without balance
+	includeVotes(poll, *blockchain.NewVote(privates[3], candidates[2]))
+	includeVotes(poll, *blockchain.NewVote(privates[0], candidates[0]))
+	includeVotes(poll, *blockchain.NewVote(privates[1], candidates[1]))
+	includeVotes(poll, *blockchain.NewVote(privates[2], candidates[1]))
+	now := nextRound(poll)
+
+	s := state(poll)
+	if s.votes[rich].votes != 100 || s.votes[poor].votes != 30 || s.votes[third].votes != 0 {
+		t.Fatalf("votes weighted incorrectly: %v", s.top)
+	}
+	if poll.GetPosition(rich, now) != 0 || poll.GetPosition(poor, now) != 1 {
+		t.Fatalf("top is not ordered by weight: %v", s.top)
+	}
+
+	//rich voter spent its balance
+	poll.SetWeight(ids[0], 5)
+	now = nextRound(poll)
+	if s = state(poll); s.votes[rich].votes != 5 || poll.GetPosition(poor, now) != 0 || poll.GetPosition(rich, now) != 1 {
+		t.Fatalf("weight change not followed: %v", s.top)
+	}
+
+	poll.SetWeight(ids[3], 50)
+	includeVotes(poll, *blockchain.NewVote(privates[3], candidates[2]))
+	now = nextRound(poll)
+	if poll.GetPosition(third, now) != 0 || poll.IsElected(rich, now) {
+		t.Fatalf("top not rebuilt: %v", state(poll).top)
+	}
+}
+
+//includeVotes applies block with the votes in the current round
+func includeVotes(poll *Poll, votes ...blockchain.Vote) {
+	start := poll.genesisStart + state(poll).round*poll.period*int64(poll.maxDelegates)
+	poll.ApplyBlock(blockchain.BlockData{Unit: blockchain.Unit{TimeStamp: start}, Votes: votes})
+}
+
+//nextRound applies empty block of the next round, returns the round start
+func nextRound(poll *Poll) int64 {
+	start := poll.genesisStart + (state(poll).round+1)*poll.period*int64(poll.maxDelegates)
+	poll.ApplyBlock(blockchain.BlockData{Unit: blockchain.Unit{TimeStamp: start}})
+	return start
+}
+
+//getTestStartTime returns genesis start so that current time is 42.742 sec after it, rounded down to whole seconds
 func getTestStartTime() int64 {
-	return time.Now().UTC().UnixNano() - int64(42742*time.Millisecond)
+	now := time.Now().UTC().UnixNano()
+	return now - now%int64(time.Second) - int64(42742*time.Millisecond)
+}
+
+func TestPoll_Withdraw(t *testing.T) {
+	poll := NewPoll(2, 2, 0, 0)
+	private, public, _ := blockchain.NewKeys()
+	voter, _ := peer.IDFromPublicKey(public)
+	poll.SetWeight(voter.Pretty(), 10)
+	candidate := peer.ID("candidate")
+
+	includeVotes(poll, *blockchain.NewVote(private, candidate))
+	nextRound(poll)
+	now := nextRound(poll)
+	if !poll.IsElected(candidate.Pretty(), now) {
+		t.Fatalf("standing vote lost: %v", poll.GetSchedule(now))
+	}
+
+	includeVotes(poll, *blockchain.NewWithdrawal(private, candidate))
+	now = nextRound(poll)
+	s := state(poll)
+	if poll.IsElected(candidate.Pretty(), now) || s.votes[candidate.Pretty()].votes != 0 || len(s.votes[voter.Pretty()].votedFor) != 0 {
+		t.Fatalf("vote not withdrawn: %v", s.votes)
+	}
+}
+
+func TestPoll_ApplyBlock(t *testing.T) {
+	poll := NewPoll(3, 1, 0, 0)
+	roundDuration := poll.period * int64(poll.maxDelegates)
+	private, public, _ := blockchain.NewKeys()
+	voter, _ := peer.IDFromPublicKey(public)
+	poll.SetWeight(voter.Pretty(), 10)
+	candidate := peer.ID("candidate").Pretty()
+
+	first := blockchain.BlockData{Unit: blockchain.Unit{TimeStamp: roundDuration + poll.period}}
+	poll.ApplyBlock(first)
+	second := blockchain.BlockData{Unit: blockchain.Unit{TimeStamp: roundDuration + 2*poll.period},
+		Votes: []blockchain.Vote{*blockchain.NewVote(private, "candidate")}}
+	poll.ApplyBlock(second)
+
+	if poll.IsElected(candidate, second.GetTimestamp()) {
+		t.Fatalf("vote changed schedule of the round it was included in: %v", poll.GetSchedule(second.GetTimestamp()))
+	}
+	//no blocks in the 2nd round yet
+	if !poll.IsElected(candidate, 2*roundDuration) || !poll.IsElected(candidate, 3*roundDuration) {
+		t.Fatalf("vote doesn't count in next rounds: %v", poll.GetSchedule(2*roundDuration))
+	}
+
+	//block in the 4th round after empty 2nd and 3rd
+	poll.ApplyBlock(blockchain.BlockData{Unit: blockchain.Unit{TimeStamp: 4 * roundDuration}})
+	if !poll.IsElected(candidate, 2*roundDuration) || !poll.IsElected(candidate, 4*roundDuration) ||
+		poll.IsElected(candidate, roundDuration) {
+		t.Fatalf("schedules of rounds without blocks are wrong: %v", state(poll).schedules)
+	}
+	if schedule := poll.GetSchedule(4 * roundDuration); len(schedule) != 1 || schedule[0] != candidate {
+		t.Fatalf("wrong schedule: %v", schedule)
+	}
+}
+
+func TestPoll_RevertBlock(t *testing.T) {
+	poll := NewPoll(3, 1, 0, 0)
+	roundDuration := poll.period * int64(poll.maxDelegates)
+	privates := make([]crypto.PrivKey, 2)
+	for i := range privates {
+		private, public, _ := blockchain.NewKeys()
+		voter, _ := peer.IDFromPublicKey(public)
+		poll.SetWeight(voter.Pretty(), 10)
+		privates[i] = private
+	}
+	first, second := peer.ID("first"), peer.ID("second")
+
+	b1 := blockchain.BlockData{Unit: blockchain.Unit{Hash: "b1", TimeStamp: roundDuration},
+		Votes: []blockchain.Vote{*blockchain.NewVote(privates[0], first), *blockchain.NewVote(privates[1], first)}}
+	b2 := blockchain.BlockData{Unit: blockchain.Unit{Hash: "b2", TimeStamp: 2 * roundDuration},
+		Votes: []blockchain.Vote{*blockchain.NewVote(privates[1], second)}}
+	poll.ApplyBlock(b1)
+	poll.ApplyBlock(b2)
+	if s := state(poll); s.votes[first.Pretty()].votes != 10 || !poll.IsElected(second.Pretty(), 3*roundDuration) {
+		t.Fatalf("votes not applied: %v", s.votes)
+	}
+
+	if err := poll.RevertBlock(b1); err == nil {
+		t.Fatal("reverted block which is not the last one")
+	}
+	if err := poll.RevertBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if s := state(poll); s.votes[first.Pretty()].votes != 20 || s.votes[second.Pretty()].votes != 0 {
+		t.Fatalf("votes not reverted: %v", s.votes)
+	}
+	if !poll.IsElected(first.Pretty(), 2*roundDuration) || poll.IsElected(second.Pretty(), 2*roundDuration) {
+		t.Fatalf("schedule of reverted round is kept: %v", state(poll).schedules)
+	}
+
+	poll.RevertBlock(b1)
+	if s := state(poll); s.votes[first.Pretty()].votes != 0 || len(s.top) != 0 || len(s.schedules) != 0 {
+		t.Fatalf("poll not reverted to initial state: %v %v", s.votes, s.top)
+	}
+}
+
+func TestPoll_Snapshot(t *testing.T) {
+	poll := NewPoll(3, 1, 0, 0)
+	roundDuration := poll.period * int64(poll.maxDelegates)
+	private, public, _ := blockchain.NewKeys()
+	voter, _ := peer.IDFromPublicKey(public)
+	poll.SetWeight(voter.Pretty(), 10)
+	first, second := peer.ID("first").Pretty(), peer.ID("second").Pretty()
+
+	poll.ApplyBlock(blockchain.BlockData{Unit: blockchain.Unit{Hash: "b1", TimeStamp: roundDuration},
+		Votes: []blockchain.Vote{*blockchain.NewVote(private, "first")}})
+	snapshot := poll.Snapshot().(*Snapshot)
+
+	poll.ApplyBlock(blockchain.BlockData{Unit: blockchain.Unit{Hash: "b2", TimeStamp: 2 * roundDuration},
+		Votes: []blockchain.Vote{*blockchain.NewVote(private, "second")}})
+	if !poll.IsElected(second, 3*roundDuration) {
+		t.Fatalf("vote not applied: %v", poll.GetSchedule(3*roundDuration))
+	}
+
+	poll.Restore(snapshot)
+	if s := state(poll); !poll.IsElected(first, 3*roundDuration) || poll.IsElected(second, 3*roundDuration) || s.round != 1 {
+		t.Fatalf("snapshot not restored: %v", s.votes)
+	}
+	//poll state is not shared with snapshot
+	poll.ApplyBlock(blockchain.BlockData{Unit: blockchain.Unit{Hash: "b2", TimeStamp: 2 * roundDuration},
+		Votes: []blockchain.Vote{*blockchain.NewVote(private, "second")}})
+	if len(snapshot.votes[voter.Pretty()].votedFor) != 1 || snapshot.votes[voter.Pretty()].votedFor[0] != first {
+		t.Fatalf("snapshot changed: %v", snapshot.votes)
+	}
+
+	poll.Reset()
+	if s := state(poll); len(s.votes) != 0 || len(s.weights) != 0 || len(s.schedules) != 0 || poll.IsElected(second, 3*roundDuration) {
+		t.Fatalf("poll not reset: %v", s.votes)
+	}
+}
+
+func TestPoll_MissedSlots(t *testing.T) {
+	viper.Set("poll.genesisDelegates", []string{"a", "b", "c"})
+	viper.Set("poll.maxMissRate", 0.5)
+	defer viper.Set("poll.genesisDelegates", []string{})
+	defer viper.Set("poll.maxMissRate", 0.0)
+	poll := NewPoll(3, 1, 0, 0)
+	roundDuration := poll.period * int64(poll.maxDelegates)
+
+	//c never produces, its slot of every round is missed
+	var blocks []blockchain.BlockData
+	for round := int64(1); round <= 11; round++ {
+		for slot, signer := range []string{"a", "b"} {
+			bd := blockchain.BlockData{Unit: blockchain.Unit{Hash: fmt.Sprintf("%s%d", signer, round), Signer: signer,
+				TimeStamp: round*roundDuration + int64(slot)*poll.period}}
+			poll.ApplyBlock(bd)
+			blocks = append(blocks, bd)
+		}
+	}
+
+	if schedule := poll.GetSchedule(10 * roundDuration); len(schedule) != 3 {
+		t.Errorf("delegate skipped before its miss rate was judged: %v", schedule)
+	}
+	if schedule := poll.GetSchedule(11 * roundDuration); len(schedule) != 2 || schedule[0] != "a" || schedule[1] != "b" {
+		t.Errorf("unreliable delegate not skipped: %v", schedule)
+	}
+	stats := poll.GetStats()
+	if stats["a"] != (ProductionStats{11, 0}) || stats["b"] != (ProductionStats{11, 1}) || stats["c"] != (ProductionStats{0, 1}) {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	if schedule := poll.GetSchedule(12 * roundDuration); len(schedule) != 3 {
+		t.Errorf("skipped delegate didn't get back: %v", schedule)
+	}
+
+	poll.RevertBlock(blocks[len(blocks)-1])
+	poll.RevertBlock(blocks[len(blocks)-2])
+	stats = poll.GetStats()
+	if stats["a"] != (ProductionStats{10, 0}) || stats["c"] != (ProductionStats{0, 10}) {
+		t.Errorf("stats not reverted: %v", stats)
+	}
+}
+
+func TestPoll_Disqualify(t *testing.T) {
+	viper.Set("poll.genesisDelegates", []string{"a", "b", "c"})
+	defer viper.Set("poll.genesisDelegates", []string{})
+	poll := NewPoll(3, 1, 0, 0)
+	roundDuration := poll.period * int64(poll.maxDelegates)
+
+	offence := blockchain.Equivocation{First: blockchain.BlockHeader{Signer: "b"}, Second: blockchain.BlockHeader{Signer: "b"}}
+	withEvidence := blockchain.BlockData{Unit: blockchain.Unit{Hash: "a1", Signer: "a", TimeStamp: roundDuration},
+		Evidence: []blockchain.Equivocation{offence}}
+	poll.ApplyBlock(withEvidence)
+
+	if schedule := poll.GetSchedule(roundDuration); len(schedule) != 3 {
+		t.Errorf("schedule of the round evidence was included in changed: %v", schedule)
+	}
+	if schedule := poll.GetSchedule(2 * roundDuration); len(schedule) != 2 || schedule[0] != "a" || schedule[1] != "c" {
+		t.Errorf("offender not disqualified: %v", schedule)
+	}
+
+	poll.RevertBlock(withEvidence)
+	if schedule := poll.GetSchedule(2 * roundDuration); len(schedule) != 3 {
+		t.Errorf("disqualification not reverted: %v", schedule)
+	}
 }
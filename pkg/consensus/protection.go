@@ -0,0 +1,111 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+//SignedRecord is the last slot signed by producer key
+type SignedRecord struct {
+	Slot int64
+}
+
+//SlashingProtection persists last signed slot of every producer key.
+//It is consulted before every block signature, so that producer never signs two blocks for the same slot,
+//even after restart with wrong clock or when standby node with the same key takes over.
+//Chain height is not checked, as node restarted from a shorter chain legitimately signs lower heights again.
+type SlashingProtection struct {
+	records map[string]SignedRecord
+	path    string
+	sync.Mutex
+}
+
+func NewSlashingProtection(path string) (p *SlashingProtection, err error) {
+	p = &SlashingProtection{records: make(map[string]SignedRecord), path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&p.records)
+	if err != nil {
+		err = fmt.Errorf("failed to read slashing protection records from %s: %s", path, err)
+	}
+	return
+}
+
+//CheckAndRecord returns error if signer has already signed block for the same or later slot,
+//otherwise it records new slot before returning, so signature may be made safely
+func (p *SlashingProtection) CheckAndRecord(signer string, slot int64) (err error) {
+	p.Lock()
+	defer p.Unlock()
+	last, ok := p.records[signer]
+	if ok && slot <= last.Slot {
+		return fmt.Errorf("refusing to sign slot %d, slot %d has already been signed by %s", slot, last.Slot, signer)
+	}
+	p.records[signer] = SignedRecord{slot}
+	err = p.save()
+	if err != nil {
+		p.records[signer] = last
+		if !ok {
+			delete(p.records, signer)
+		}
+		err = fmt.Errorf("failed to persist slashing protection record: %s", err)
+	}
+	return
+}
+
+func (p *SlashingProtection) Record(signer string) (record SignedRecord, ok bool) {
+	p.Lock()
+	defer p.Unlock()
+	record, ok = p.records[signer]
+	return
+}
+
+//Export writes all records, so that they can be imported on another machine
+func (p *SlashingProtection) Export(w io.Writer) error {
+	p.Lock()
+	defer p.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p.records)
+}
+
+//Import merges exported records, the latest slot is kept for every key
+func (p *SlashingProtection) Import(r io.Reader) (err error) {
+	var imported map[string]SignedRecord
+	err = json.NewDecoder(r).Decode(&imported)
+	if err != nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	for signer, record := range imported {
+		last := p.records[signer]
+		if record.Slot < last.Slot {
+			record.Slot = last.Slot
+		}
+		p.records[signer] = record
+	}
+	return p.save()
+}
+
+func (p *SlashingProtection) save() error {
+	bytes, err := json.Marshal(p.records)
+	if err != nil {
+		return err
+	}
+	tmpPath := p.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, bytes, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, p.path)
+}
@@ -0,0 +1,54 @@
+package consensus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSlashingProtection(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "protection")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "protection.json")
+
+	p, err := NewSlashingProtection(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.CheckAndRecord("producer", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.CheckAndRecord("producer", 10); err == nil {
+		t.Fatal("signed the same slot twice")
+	}
+	if err = p.CheckAndRecord("other", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewSlashingProtection(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = restarted.CheckAndRecord("producer", 9); err == nil {
+		t.Fatal("records lost on restart")
+	}
+
+	var exported bytes.Buffer
+	if err = restarted.Export(&exported); err != nil {
+		t.Fatal(err)
+	}
+	standby, _ := NewSlashingProtection(filepath.Join(dir, "standby.json"))
+	standby.CheckAndRecord("producer", 12)
+	standby.CheckAndRecord("other", 3)
+	if err = standby.Import(&exported); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := standby.Record("producer"); record.Slot != 12 {
+		t.Fatalf("records merged incorrectly: %+v", record)
+	}
+	if err = standby.CheckAndRecord("other", 10); err == nil {
+		t.Fatal("imported record ignored")
+	}
+}